@@ -0,0 +1,70 @@
+package conf
+
+import (
+	"time"
+
+	"github.com/wweir/sower/util"
+	"github.com/wweir/utils/log"
+)
+
+// AggregateDynamic propose the wildcard rules for dynamic list, without applying them.
+// min is the siblings required, dynamic_aggregate is used if 0.
+func AggregateDynamic(min int) []util.Aggregation {
+	if min == 0 {
+		min = Client.Router.DynamicAggregate
+	}
+
+	flushMu.Lock()
+	defer flushMu.Unlock()
+	return util.Aggregate(Client.Router.DynamicList, min, Client.Router.DirectList)
+}
+
+// aggregateDynamic replace the dynamic rules by the wildcard ones proposed,
+// should be called with flushMu locked
+func aggregateDynamic(min int) {
+	aggs := util.Aggregate(Client.Router.DynamicList, min, Client.Router.DirectList)
+	if len(aggs) == 0 {
+		return
+	}
+
+	mergeDynamicHits()
+	history := map[string]*dynamicEntry{}
+	for _, e := range Client.Router.DynamicHistory {
+		history[e.Domain] = e
+	}
+
+	now := time.Now()
+	for _, agg := range aggs {
+		entry := &dynamicEntry{Domain: agg.Rule, LearnedAt: now, VerifiedAt: now}
+		for _, domain := range agg.Covered {
+			e, ok := history[domain]
+			if !ok {
+				continue
+			}
+			if e.LearnedAt.Before(entry.LearnedAt) {
+				entry.LearnedAt = e.LearnedAt
+			}
+			if e.lastUsed().After(entry.LastHit) {
+				entry.LastHit = e.lastUsed()
+			}
+			delete(history, domain)
+		}
+		history[agg.Rule] = entry
+		log.Infow("aggregate rules", "rule", agg.Rule, "covered", agg.Covered)
+	}
+
+	entries := make([]*dynamicEntry, 0, len(history))
+	for _, e := range Client.Router.DynamicHistory {
+		if history[e.Domain] == e {
+			entries = append(entries, e)
+			delete(history, e.Domain)
+		}
+	}
+	for _, agg := range aggs {
+		if e, ok := history[agg.Rule]; ok {
+			entries = append(entries, e)
+		}
+	}
+	Client.Router.DynamicHistory = entries
+	setDynamic()
+}
@@ -0,0 +1,88 @@
+package conf
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/wweir/sower/util"
+)
+
+type clientPolicy struct {
+	block    *util.Node
+	proxy    *util.Node
+	direct   *util.Node
+	outbound string // empty to route by the shared rules and lists
+}
+
+// loadPolicies map the client cidrs to their policies, the longest cidr wins
+func loadPolicies() (*util.IPNode, error) {
+	if len(Client.Router.Clients) == 0 {
+		return nil, nil
+	}
+
+	clients := &util.IPNode{}
+	for i, c := range Client.Router.Clients {
+		policy := &clientPolicy{outbound: c.Outbound}
+		switch c.Outbound {
+		case "", OutboundDirect, OutboundBlock, OutboundProxy:
+		default:
+			if _, ok := Client.Outbounds[c.Outbound]; !ok {
+				return nil, fmt.Errorf("client %d: outbound %q not configured", i, c.Outbound)
+			}
+		}
+
+		if len(c.CIDRs) == 0 {
+			return nil, fmt.Errorf("client %d: no cidrs", i)
+		}
+		for _, cidr := range c.CIDRs {
+			if err := clients.Add(cidr, policy); err != nil {
+				return nil, fmt.Errorf("client %d: %w", i, err)
+			}
+		}
+
+		if len(c.BlockList) != 0 {
+			policy.block = util.NewNodeFromRules(c.BlockList...)
+		}
+		if len(c.ProxyList) != 0 {
+			policy.proxy = util.NewNodeFromRules(c.ProxyList...)
+		}
+		if len(c.DirectList) != 0 {
+			policy.direct = util.NewNodeFromRules(c.DirectList...)
+		}
+	}
+
+	return clients, nil
+}
+
+// matchPolicy return the policy of the client, nil if not configured
+func matchPolicy(client net.IP) *clientPolicy {
+	if client == nil {
+		return nil
+	}
+	if val, ok := loaded().clients.Lookup(client); ok {
+		return val.(*clientPolicy)
+	}
+	return nil
+}
+
+// route pick the outbound by the lists of the policy, ok is false if none matched
+func (p *clientPolicy) route(host string) (outbound, list, rule string, ok bool) {
+	if rule, ok := p.block.MatchRule(host); ok {
+		return OutboundBlock, "client.router.clients block_list", rule, true
+	}
+	if rule, ok := p.direct.MatchRule(host); ok {
+		return OutboundDirect, "client.router.clients direct_list", rule, true
+	}
+	if rule, ok := p.proxy.MatchRule(host); ok {
+		return OutboundProxy, "client.router.clients proxy_list", rule, true
+	}
+	if p.outbound == "" {
+		return "", "", "", false
+	}
+
+	// the shared block list still applies to the clients forced to an outbound
+	if list, rule := matchBlock(host); list != "" {
+		return OutboundBlock, list, rule, true
+	}
+	return p.outbound, "client.router.clients outbound", p.outbound, true
+}
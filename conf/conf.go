@@ -7,6 +7,9 @@ import (
 	"time"
 
 	toml "github.com/pelletier/go-toml"
+	"github.com/wweir/sower/internal/detect"
+	"github.com/wweir/sower/internal/geoip"
+	"github.com/wweir/sower/internal/rule"
 	"github.com/wweir/sower/util"
 	"github.com/wweir/utils/log"
 )
@@ -14,27 +17,131 @@ import (
 type client struct {
 	Address string `toml:"address"`
 
+	// named upstreams for router rules, password defaults to the global one
+	Outbounds map[string]struct {
+		Address  string `toml:"address"`
+		Password string `toml:"password"`
+	} `toml:"outbounds"`
+
 	HTTPProxy struct {
 		Address string `toml:"address"`
 	} `toml:"http_proxy"`
 
+	// local stats and controls, served to loopback clients only
+	Status struct {
+		Address string `toml:"address"`
+	} `toml:"status"`
+
 	DNS struct {
-		ServeIP  string `toml:"serve_ip"`
-		Upstream string `toml:"upstream"`
-		FlushCmd string `toml:"flush_cmd"`
+		ServeIP   string `toml:"serve_ip"`
+		ServeIP6  string `toml:"serve_ip6"`
+		Upstream  string `toml:"upstream"`
+		Strategy  string `toml:"strategy"`
+		FlushCmd  string `toml:"flush_cmd"`
+		CacheSize int    `toml:"cache_size"`
+		Prefetch  bool   `toml:"prefetch"`
+
+		RemoteResolve  bool   `toml:"remote_resolve"`
+		RemoteUpstream string `toml:"remote_upstream"`
+		DetectPoison   bool   `toml:"detect_poison"`
+
+		BlockMode  string              `toml:"block_mode"`
+		Hosts      map[string][]string `toml:"hosts"`
+		HostsFiles []string            `toml:"hosts_files"`
+
+		Forward []struct {
+			Domains  []string `toml:"domains"`
+			Upstream string   `toml:"upstream"`
+			Strategy string   `toml:"strategy"`
+		} `toml:"forward"`
+
+		FakeIP struct {
+			CIDR   string `toml:"cidr"`
+			Listen string `toml:"listen"`
+			File   string `toml:"file"`
+		} `toml:"fake_ip"`
+
+		QueryLog struct {
+			Size    int    `toml:"size"`
+			File    string `toml:"file"`
+			MaxSize int64  `toml:"max_size"`
+			Backups int    `toml:"backups"`
+		} `toml:"query_log"`
 	} `toml:"dns"`
 
 	Router struct {
-		PortMapping   map[string]string `toml:"port_mapping"`
-		DetectLevel   int               `toml:"detect_level"`
-		DetectTimeout string            `toml:"detect_timeout"`
-
-		ProxyList    []string `toml:"proxy_list"`
-		DirectList   []string `toml:"direct_list"`
-		DynamicList  []string `toml:"dynamic_list"`
-		directRules  *util.Node
-		proxyRules   *util.Node
-		dynamicRules *util.Node
+		PortMapping map[string]string `toml:"port_mapping"`
+
+		// checked in order before the lists, the first matched rule picks the outbound
+		Rules []struct {
+			Domains  []string `toml:"domains"`
+			CIDRs    []string `toml:"cidrs"`
+			Ports    []uint16 `toml:"ports"`
+			Inbounds []string `toml:"inbounds"`
+			Outbound string   `toml:"outbound"`
+		} `toml:"rules"`
+
+		// per-client policies by the source ip of dns queries and proxy connections
+		Clients []struct {
+			CIDRs      []string `toml:"cidrs"`
+			Outbound   string   `toml:"outbound"` // empty to route by the shared rules and lists
+			BlockList  []string `toml:"block_list"`
+			ProxyList  []string `toml:"proxy_list"`
+			DirectList []string `toml:"direct_list"`
+		} `toml:"clients"`
+
+		DetectLevel   int    `toml:"detect_level"`
+		DetectTimeout string `toml:"detect_timeout"`
+		DetectWorkers int    `toml:"detect_workers"` // concurrent detections, applied on start
+		DetectQueue   int    `toml:"detect_queue"`   // pending detections, the others go direct
+		DetectAsync   bool   `toml:"detect_async"`   // go direct without waiting for detection
+
+		// repeated direct failures of http(s) proxy route the domain though proxy for a while
+		FailThreshold int    `toml:"fail_threshold"`
+		FailProxyTTL  string `toml:"fail_proxy_ttl"`
+		FailRetry     bool   `toml:"fail_retry"` // retry the failed request though proxy if replayable
+		Detectors     []struct {
+			Type   string `toml:"type"` // tcp / tls / http / dns
+			Port   uint16 `toml:"port"`
+			Weight int    `toml:"weight"`
+		} `toml:"detectors"`
+
+		ProxyList        []string        `toml:"proxy_list"`
+		DirectList       []string        `toml:"direct_list"`
+		DynamicList      []string        `toml:"dynamic_list"`
+		DynamicMax       int             `toml:"dynamic_max"`       // keep the recently used ones, 0 for unlimited
+		DynamicStale     string          `toml:"dynamic_stale"`     // re-detect the rules not verified for, eg: 168h
+		DynamicAggregate int             `toml:"dynamic_aggregate"` // siblings to aggregate into wildcard, 0 to disable
+		DynamicHistory   []*dynamicEntry `toml:"dynamic_history"`
+
+		DetectCacheTTL  string     `toml:"detect_cache_ttl"`  // keep the direct verdicts for
+		DetectCacheSize int        `toml:"detect_cache_size"` // 0 for unlimited
+		DirectVerdicts  []*verdict `toml:"direct_verdicts"`
+		BlockList       []string   `toml:"block_list"`
+		BlockFiles      []string   `toml:"block_files"`
+		Providers       []struct {
+			File     string `toml:"file"`
+			URL      string `toml:"url"`
+			Format   string `toml:"format"`
+			List     string `toml:"list"`
+			Interval string `toml:"interval"`
+			Cache    string `toml:"cache"`
+			Proxy    bool   `toml:"proxy"`
+		} `toml:"providers"`
+
+		// keyword and regex rules are checked after the suffix ones, direct wins in the same type
+		ProxyKeyword  []string `toml:"proxy_keyword"`
+		DirectKeyword []string `toml:"direct_keyword"`
+		ProxyRegex    []string `toml:"proxy_regex"`
+		DirectRegex   []string `toml:"direct_regex"`
+
+		ProxyCIDR  []string `toml:"proxy_cidr"`
+		DirectCIDR []string `toml:"direct_cidr"`
+		GeoIP      struct {
+			File          string   `toml:"file"`
+			ProxyCountry  []string `toml:"proxy_country"`
+			DirectCountry []string `toml:"direct_country"`
+		} `toml:"geoip"`
 	} `toml:"router"`
 }
 type server struct {
@@ -49,7 +156,7 @@ var (
 
 	flushOnce = sync.Once{}
 	flushMu   = sync.Mutex{}
-	flushCh   = make(chan struct{})
+	flushCh   = make(chan struct{}, 1) // keep a pending flush
 
 	Server = server{}
 	Client = client{}
@@ -61,17 +168,23 @@ var (
 	Password      string
 	installCmd    string
 	uninstallFlag bool
+	inspecting    bool // explain / aggregate, load the config without side effects
 )
 
 func init() {
+	providerFetched = triggerFlush
+
 	flag.StringVar(&Password, "password", "", "password")
 	flag.StringVar(&Server.Upstream, "s", "", "upstream http service, eg: 127.0.0.1:8080")
 	flag.StringVar(&Server.CertFile, "s_cert", "", "tls cert file, gen cert from letsencrypt if empty")
 	flag.StringVar(&Server.KeyFile, "s_key", "", "tls key file, gen cert from letsencrypt if empty")
 	flag.StringVar(&Client.Address, "c", "", "remote server domain, eg: aa.bb.cc, socks5h://127.0.0.1:1080")
 	flag.StringVar(&Client.HTTPProxy.Address, "http_proxy", ":8080", "http proxy, empty to disable")
+	flag.StringVar(&Client.Status.Address, "status", "127.0.0.1:8081", "local stats at /debug/vars, empty to disable")
 	flag.StringVar(&Client.DNS.ServeIP, "dns_ip", "", "upstream dns, eg: 127.0.0.1, disable dns proxy if empty")
-	flag.StringVar(&Client.DNS.Upstream, "dns_upstream", "", "dns relay server ip, dynamic detect if empty")
+	flag.StringVar(&Client.DNS.ServeIP6, "dns_ip6", "", "upstream dns in ipv6, eg: ::1, answer no AAAA record if empty")
+	flag.StringVar(&Client.DNS.Upstream, "dns_upstream", "", "dns relay servers, comma separated, dynamic detect if empty")
+	flag.IntVar(&Client.DNS.CacheSize, "dns_cache", 1024, "dns cache size, 0 to disable")
 	flag.IntVar(&Client.Router.DetectLevel, "level", 2, "dynamic rule detect level: 0~4")
 	flag.StringVar(&Client.Router.DetectTimeout, "timeout", "300ms", "dynamic rule detect timeout")
 	flag.BoolVar(&uninstallFlag, "uninstall", false, "uninstall service")
@@ -88,6 +201,7 @@ func init() {
 		install()
 		os.Exit(0)
 	}
+	inspecting = flag.Arg(0) == "explain" || flag.Arg(0) == "aggregate"
 
 	var err error
 	defer func() {
@@ -97,17 +211,44 @@ func init() {
 
 		log.Infow("start", "version", version, "date", date, "conf", &conf)
 		passwordData = []byte(Password)
+		if inspecting {
+			return // detect nothing in background
+		}
+
+		workers, queue := Client.Router.DetectWorkers, Client.Router.DetectQueue
+		if workers <= 0 {
+			workers = 8
+		}
+		if queue <= 0 {
+			queue = 256
+		}
+		detecting = detect.NewPool(workers, queue, detectDynamic)
 	}()
 
 	if conf.file == "" {
+		// no rule loaded, but learned and edited at runtime
+		compiled.Store(&matchers{
+			directRules:  util.NewNodeFromRules(),
+			proxyRules:   util.NewNodeFromRules(),
+			blockRules:   util.NewNodeFromRules(),
+			dynamicRules: util.NewNodeFromRules(),
+		})
 		return
 	}
 
 	for i := range loadConfigFns {
+		if inspecting && loadConfigFns[i].step == "flush_dns" {
+			continue
+		}
 		if err = loadConfigFns[i].fn(); err != nil {
 			log.Fatalw("load config", "config", conf.file, "step", loadConfigFns[i].step, "err", err)
 		}
 	}
+	if inspecting {
+		return
+	}
+	go verifyDynamic()
+	go flushVerdicts()
 }
 
 // refreshFns will be executed while init and write new config
@@ -121,12 +262,108 @@ var loadConfigFns = []struct {
 	}
 	defer f.Close()
 
-	return toml.NewDecoder(f).Decode(&conf)
+	err = toml.NewDecoder(f).Decode(&conf)
+	// the decoder fills Server and Client, but points conf to the copies of them,
+	// which are never updated at runtime and flushed stale
+	conf.Server, conf.Client = &Server, &Client
+	return err
 
 }}, {"load_rules", func() error {
-	Client.Router.directRules = util.NewNodeFromRules(Client.Router.DirectList...)
-	Client.Router.proxyRules = util.NewNodeFromRules(Client.Router.ProxyList...)
-	Client.Router.dynamicRules = util.NewNodeFromRules(Client.Router.DynamicList...)
+	// everything is compiled into m and published as a whole at last,
+	// the live rules are kept if any step fails
+	m, last := &matchers{}, loaded()
+	ttl := 2 * time.Hour
+	if Client.Router.DetectCacheTTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(Client.Router.DetectCacheTTL); err != nil {
+			return err
+		}
+	}
+	if Client.Router.FailProxyTTL != "" {
+		var err error
+		if m.failProxyTTL, err = time.ParseDuration(Client.Router.FailProxyTTL); err != nil {
+			return err
+		}
+	}
+	if _, err := newDetectors(); err != nil {
+		return err
+	}
+
+	for _, spec := range Client.Router.BlockFiles {
+		format, file := rule.SplitFormat(spec)
+		rules, bad, err := rule.ParseFileFormat(file, format)
+		if err != nil {
+			return err
+		}
+		m.blockFiles = append(m.blockFiles, rules...)
+		if len(bad) != 0 {
+			log.Infow("skip untranslatable block rules", "file", file, "count", len(bad), "first", bad[0])
+		}
+	}
+	var err error
+	m.proxyKeyword = append([]string{}, Client.Router.ProxyKeyword...)
+	m.directKeyword = append([]string{}, Client.Router.DirectKeyword...)
+	m.proxyKeywords = util.NewKeywordMatcher(m.proxyKeyword...)
+	m.directKeywords = util.NewKeywordMatcher(m.directKeyword...)
+	if m.proxyRegexes, err = util.NewRegexMatcher(Client.Router.ProxyRegex...); err != nil {
+		return err
+	}
+	if m.directRegexes, err = util.NewRegexMatcher(Client.Router.DirectRegex...); err != nil {
+		return err
+	}
+
+	// direct wins on the same cidr
+	m.cidrRules = &util.IPNode{}
+	for _, cidr := range Client.Router.ProxyCIDR {
+		if err := m.cidrRules.Add(cidr, &cidrRule{true, "proxy_cidr " + cidr}); err != nil {
+			return err
+		}
+	}
+	for _, cidr := range Client.Router.DirectCIDR {
+		if err := m.cidrRules.Add(cidr, &cidrRule{false, "direct_cidr " + cidr}); err != nil {
+			return err
+		}
+	}
+
+	// the db is large, open it again only if the path changed
+	m.geoip, m.geoipFile = last.geoip, last.geoipFile
+	if file := Client.Router.GeoIP.File; file != m.geoipFile {
+		if file == "" {
+			m.geoip = nil
+		} else if m.geoip, err = geoip.Open(file); err != nil {
+			return err
+		}
+		m.geoipFile = file
+	}
+	m.proxyCountry = append([]string{}, Client.Router.GeoIP.ProxyCountry...)
+	m.directCountry = append([]string{}, Client.Router.GeoIP.DirectCountry...)
+	m.ipRules = len(Client.Router.ProxyCIDR)+len(Client.Router.DirectCIDR) != 0 || m.geoip != nil
+
+	if m.rules, err = loadRoutes(); err != nil {
+		return err
+	}
+	if m.clients, err = loadPolicies(); err != nil {
+		return err
+	}
+	// the last step can fail, as the refresh loops of the last providers are stopped
+	if m.providers, err = loadProviders(last.providers); err != nil {
+		return err
+	}
+
+	// the lists are edited at runtime, read and published with flushMu locked
+	flushMu.Lock()
+	m.directRules = util.NewNodeFromRules(Client.Router.DirectList...)
+	m.proxyRules = util.NewNodeFromRules(Client.Router.ProxyList...)
+	m.blockRules = util.NewNodeFromRules(m.blockList()...)
+	loadDynamicHistory()
+	evictDynamic()
+	sortDynamic()
+	m.dynamicRules = util.NewNodeFromRules(Client.Router.DynamicList...)
+	verdicts.reset(ttl, Client.Router.DetectCacheSize, Client.Router.DirectVerdicts)
+	compiled.Store(m)
+	flushMu.Unlock()
+
+	ipVerdicts.forget(nil)
 	return nil
 
 }}, {"flush_dns", func() error {
@@ -147,6 +384,11 @@ func flushConf() {
 			}
 
 			flushMu.Lock()
+			mergeDynamicHits()
+			if Client.Router.DynamicAggregate > 0 {
+				aggregateDynamic(Client.Router.DynamicAggregate) // once per flush, not per insert
+			}
+			Client.Router.DirectVerdicts = verdicts.dump()
 			if err := toml.NewEncoder(f).ArraysWithOneElementPerLine(true).Encode(conf); err != nil {
 				log.Errorw("flush config", "step", "flush", "err", err)
 				flushMu.Unlock()
@@ -162,8 +404,12 @@ func flushConf() {
 			}
 		}
 
-		// reload config
+		// recompile the rules, the config is not decoded again as it is just
+		// written from memory, and decoding races with the lookups in flight
 		for i := range loadConfigFns {
+			if loadConfigFns[i].step == "load_config" {
+				continue
+			}
 			if err := loadConfigFns[i].fn(); err != nil {
 				log.Errorw("flush config", "step", loadConfigFns[i].step, "err", err)
 			}
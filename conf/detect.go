@@ -0,0 +1,142 @@
+package conf
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/wweir/sower/internal/detect"
+	"github.com/wweir/sower/internal/http"
+	"github.com/wweir/sower/internal/socks5"
+	"github.com/wweir/sower/util"
+)
+
+var dnsExchange atomic.Value
+
+type exchanges struct{ local, remote detect.Exchange }
+
+// SetDNSExchange set the local and the tunnel resolvers, for the dns poisoning detector
+func SetDNSExchange(local, remote func(r *dns.Msg) (*dns.Msg, error)) {
+	dnsExchange.Store(exchanges{local, remote})
+}
+
+// dialProxy dial addr though the sower server or the socks5 proxy
+func dialProxy(addr string, timeout time.Duration) (net.Conn, error) {
+	host, port := util.ParseHostPort(addr, 0)
+	if socksAddr, ok := socks5.IsSocks5Schema(Client.Address); ok {
+		conn, err := net.DialTimeout("tcp", socksAddr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return socks5.ToSocks5(conn, host, port), nil
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp",
+		net.JoinHostPort(Client.Address, "443"), &tls.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return http.NewTgtConn(conn, passwordData, http.TGT_OTHER, host, port), nil
+}
+
+// newDetectors build the detectors in config, http:80 and tls:443 weighted 2 if not set
+func newDetectors() ([]detect.Detector, error) {
+	return newProbes(detect.DirectDialer, dialProxy)
+}
+
+// newProbes build the detectors in config with the dialers, nil to skip the side.
+// The dns detector is only built with the direct side.
+func newProbes(direct, proxy detect.Dialer) ([]detect.Detector, error) {
+	type setting struct {
+		Type   string
+		Port   uint16
+		Weight int
+	}
+	settings := []setting{{"http", 80, 2}, {"tls", 443, 2}}
+	if len(Client.Router.Detectors) != 0 {
+		settings = settings[:0]
+		for _, d := range Client.Router.Detectors {
+			settings = append(settings, setting{d.Type, d.Port, d.Weight})
+		}
+	}
+
+	detectors := make([]detect.Detector, 0, len(settings))
+	for _, s := range settings {
+		if s.Weight == 0 {
+			s.Weight = 2
+		}
+		probe := detect.Probe{
+			Port:    s.Port,
+			Weight:  s.Weight,
+			Timeout: timeout,
+			Direct:  direct,
+			Proxy:   proxy,
+		}
+
+		switch s.Type {
+		case "tcp", "tls", "http":
+			if s.Port == 0 {
+				return nil, fmt.Errorf("detector %s: port required", s.Type)
+			}
+		}
+		switch s.Type {
+		case "tcp":
+			detectors = append(detectors, &detect.TCP{Probe: probe})
+		case "tls":
+			detectors = append(detectors, &detect.TLS{Probe: probe})
+		case "http":
+			detectors = append(detectors, &detect.HTTP{Probe: probe})
+		case "dns":
+			if direct == nil {
+				continue
+			}
+			d := &detect.DNS{Weight: s.Weight}
+			if ex, ok := dnsExchange.Load().(exchanges); ok {
+				d.Local, d.Remote = ex.local, ex.remote
+			}
+			detectors = append(detectors, d)
+		default:
+			return nil, fmt.Errorf("unknown detector type %q", s.Type)
+		}
+	}
+	return detectors, nil
+}
+
+// detectScores run the detectors, the negative scores mean proxy is better
+func detectScores(domain string) (int, map[string]int) {
+	detectors, err := newDetectors()
+	if err != nil {
+		return 0, nil // validated while loading rules
+	}
+	return detect.Detect(domain, detectors...)
+}
+
+// verifyScores detect directly and though proxy apart, ok is false if nothing works though proxy,
+// eg: the proxy server or the network is down, which proves nothing about the direct route
+func verifyScores(domain string) (direct, total int, scores map[string]int, ok bool) {
+	directProbes, err := newProbes(detect.DirectDialer, nil)
+	if err != nil {
+		return 0, 0, nil, false // validated while loading rules
+	}
+	proxyProbes, _ := newProbes(nil, dialProxy)
+
+	var proxy int
+	var proxyScores map[string]int
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		proxy, proxyScores = detect.Detect(domain, proxyProbes...)
+	}()
+	direct, scores = detect.Detect(domain, directProbes...)
+	wg.Wait()
+
+	for name, score := range proxyScores {
+		scores[name] += score
+	}
+	return direct, direct + proxy, scores, proxy < 0
+}
@@ -0,0 +1,172 @@
+package conf
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wweir/sower/util"
+	"github.com/wweir/utils/log"
+)
+
+// dynamicEntry is the history of a dynamic rule, persisted in config
+type dynamicEntry struct {
+	Domain     string         `toml:"domain"`
+	LearnedAt  time.Time      `toml:"learned_at"`
+	LastHit    time.Time      `toml:"last_hit"`
+	VerifiedAt time.Time      `toml:"verified_at"`
+	Scores     map[string]int `toml:"scores"` // by detector, eg: http_80, tls_443, dns
+}
+
+func (e *dynamicEntry) lastUsed() time.Time {
+	if e.LastHit.After(e.LearnedAt) {
+		return e.LastHit
+	}
+	return e.LearnedAt
+}
+
+// dynamicHits record the last hit time of dynamic rules, merged into history while flushing
+var dynamicHits = sync.Map{}
+
+func hitDynamic(domain string) {
+	dynamicHits.Store(strings.TrimSuffix(domain, "."), time.Now())
+}
+
+// loadDynamicHistory keep history of the entries in dynamic list only,
+// the entries added by hand are treated as learned now
+func loadDynamicHistory() {
+	history := map[string]*dynamicEntry{}
+	for _, e := range Client.Router.DynamicHistory {
+		history[e.Domain] = e
+	}
+
+	now := time.Now()
+	entries := make([]*dynamicEntry, 0, len(Client.Router.DynamicList))
+	for _, domain := range Client.Router.DynamicList {
+		if e, ok := history[domain]; ok {
+			entries = append(entries, e)
+			delete(history, domain)
+		} else {
+			entries = append(entries, &dynamicEntry{Domain: domain, LearnedAt: now, VerifiedAt: now})
+		}
+	}
+	Client.Router.DynamicHistory = entries
+}
+
+// mergeDynamicHits should be called with flushMu locked
+func mergeDynamicHits() {
+	for _, e := range Client.Router.DynamicHistory {
+		if val, ok := dynamicHits.Load(e.Domain); ok {
+			if hit := val.(time.Time); hit.After(e.LastHit) {
+				e.LastHit = hit
+			}
+			dynamicHits.Delete(e.Domain)
+		}
+	}
+}
+
+// evictDynamic dedup the history and drop the least recently used entries over dynamic_max,
+// should be called with flushMu locked
+func evictDynamic() {
+	mergeDynamicHits()
+
+	entries := Client.Router.DynamicHistory
+	index := map[string]int{}
+	uniq := entries[:0]
+	for _, e := range entries {
+		if i, ok := index[e.Domain]; ok {
+			uniq[i] = e // the later one is newer
+			continue
+		}
+		index[e.Domain] = len(uniq)
+		uniq = append(uniq, e)
+	}
+	entries = uniq
+
+	if max := Client.Router.DynamicMax; max > 0 && len(entries) > max {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].lastUsed().After(entries[j].lastUsed())
+		})
+		for _, e := range entries[max:] {
+			log.Infow("evict rule", "domain", e.Domain, "last_used", e.lastUsed())
+		}
+		entries = entries[:max]
+	}
+	Client.Router.DynamicHistory = entries
+}
+
+// sortDynamic rebuild dynamic list from history, should be called with flushMu locked
+func sortDynamic() {
+	list := make([]string, 0, len(Client.Router.DynamicHistory))
+	for _, e := range Client.Router.DynamicHistory {
+		list = append(list, e.Domain)
+	}
+	Client.Router.DynamicList = util.NewReverseSecSlice(list).Sort().Uniq()
+}
+
+// setDynamic rebuild dynamic list and the live rules from history, should be called with flushMu locked
+func setDynamic() {
+	sortDynamic()
+	loaded().dynamicRules.Replace(Client.Router.DynamicList...)
+}
+
+// verifyDynamic re-detect the entries not verified for dynamic_stale periodically,
+// and demote the ones reachable directly now. Nothing is changed if proxy fails too,
+// eg: the proxy server or the network is down.
+func verifyDynamic() {
+	for {
+		flushMu.Lock()
+		stale, err := time.ParseDuration(Client.Router.DynamicStale)
+		flushMu.Unlock()
+		if err != nil || stale <= 0 {
+			time.Sleep(time.Minute) // disabled for now, check again later
+			continue
+		}
+		time.Sleep(stale / 24)
+
+		now := time.Now()
+		domains := []string{}
+		flushMu.Lock()
+		for _, e := range Client.Router.DynamicHistory {
+			if !strings.Contains(e.Domain, "*") && now.Sub(e.VerifiedAt) > stale {
+				domains = append(domains, e.Domain)
+			}
+		}
+		flushMu.Unlock()
+		if len(domains) == 0 {
+			continue
+		}
+
+		type verified struct {
+			direct, score int
+			scores        map[string]int
+			ok            bool
+		}
+		results := map[string]verified{}
+		for _, domain := range domains {
+			direct, score, scores, ok := verifyScores(domain)
+			results[domain] = verified{direct, score, scores, ok}
+		}
+
+		flushMu.Lock()
+		entries := Client.Router.DynamicHistory[:0]
+		for _, e := range Client.Router.DynamicHistory {
+			v, ok := results[e.Domain]
+			switch {
+			case !ok, !v.ok: // verify next time
+			case v.direct > 0 && v.score+Client.Router.DetectLevel >= 0:
+				log.Infow("demote rule", "domain", e.Domain, "scores", v.scores)
+				continue
+			default:
+				e.VerifiedAt, e.Scores = now, v.scores
+			}
+			entries = append(entries, e)
+		}
+		Client.Router.DynamicHistory = entries
+		setDynamic()
+		flushMu.Unlock()
+
+		triggerFlush()
+	}
+}
@@ -1,134 +1,159 @@
 package conf
 
 import (
-	"crypto/tls"
-	"net"
 	"strings"
-	"sync"
-	"sync/atomic"
 	"time"
 
-	"github.com/wweir/sower/internal/http"
-	"github.com/wweir/sower/internal/socks5"
+	"github.com/wweir/sower/internal/detect"
 	"github.com/wweir/sower/util"
 	"github.com/wweir/utils/log"
-	"github.com/wweir/utils/mem"
 )
 
-type dynamic struct {
-	port http.Port
-}
-
-var cache = mem.New(2 * time.Hour)
-var detect = &dynamic{}
 var passwordData []byte
+
+// detecting is started after config loaded
+var detecting *detect.Pool
 var timeout time.Duration
 
+// ShouldBlock check if the domain should be blocked
+func ShouldBlock(domain string) bool {
+	list, _ := matchBlock(domain)
+	return list != ""
+}
+
+// matchBlock return the list and the rule blocking the domain, empty if not blocked
+func matchBlock(domain string) (list, rule string) {
+	if rule, ok := loaded().blockRules.MatchRule(domain); ok {
+		return "block_list", rule
+	}
+	if source, ok := matchProviders("block", domain); ok {
+		return "providers", source
+	}
+	return "", ""
+}
+
 // ShouldProxy check if the domain shoule request though proxy
 func ShouldProxy(domain string) bool {
-	if domain == Client.Address {
-		return true
+	if proxy, list, _ := matchProxy(domain); list != "" {
+		if list == "dynamic_list" {
+			hitDynamic(domain)
+		}
+		return proxy
 	}
-	if Client.Router.directRules.Match(domain) {
+
+	if verdicts.detected(domain) {
 		return false
 	}
-	if Client.Router.proxyRules.Match(domain) {
-		return true
+	// route direct if the queue is full, and detect next time
+	if detecting != nil {
+		detecting.Do(domain, !Client.Router.DetectAsync)
 	}
-	if Client.Router.dynamicRules.Match(domain) {
-		return true
+	return loaded().dynamicRules.Match(domain)
+}
+
+// matchProxy return the list and the rule deciding whether the domain goes though proxy,
+// list is empty if detection is required
+func matchProxy(domain string) (proxy bool, list, rule string) {
+	m := loaded()
+	if domain == Client.Address {
+		return true, "address", domain
+	}
+	if rule, ok := m.directRules.MatchRule(domain); ok {
+		return false, "direct_list", rule
+	}
+	if source, ok := matchProviders("direct", domain); ok {
+		return false, "providers", source
+	}
+	if rule, ok := m.proxyRules.MatchRule(domain); ok {
+		return true, "proxy_list", rule
 	}
+	if source, ok := matchProviders("proxy", domain); ok {
+		return true, "providers", source
+	}
+	if id, ok := matchKeyword(m.directKeywords, domain); ok {
+		return false, "direct_keyword", m.directKeyword[id]
+	}
+	if id, ok := matchKeyword(m.proxyKeywords, domain); ok {
+		return true, "proxy_keyword", m.proxyKeyword[id]
+	}
+	if rule := m.directRegexes.MatchRule(strings.TrimSuffix(domain, ".")); rule != "" {
+		return false, "direct_regex", rule
+	}
+	if rule := m.proxyRegexes.MatchRule(strings.TrimSuffix(domain, ".")); rule != "" {
+		return true, "proxy_regex", rule
+	}
+	if rule, ok := m.dynamicRules.MatchRule(domain); ok {
+		return true, "dynamic_list", rule
+	}
+	if proxyTemporarily(domain) {
+		return true, "direct_failures", ""
+	}
+	if proxy, rule, ok := routeByIP(strings.TrimSuffix(domain, ".")); ok {
+		return proxy, "ip_rules", rule
+	}
+	return false, "", ""
+}
 
-	cache.Remember(detect, domain)
-	return Client.Router.dynamicRules.Match(domain)
+func matchKeyword(m *util.KeywordMatcher, domain string) (id int, ok bool) {
+	ok = m.MatchID(domain, func(i int) bool {
+		id = i
+		return true
+	})
+	return id, ok
 }
 
-func (d *dynamic) Get(key interface{}) (err error) {
+// detectDynamic detect the domain, and add it into dynamic list if proxy is better
+func detectDynamic(domain string) {
+	// the verdict is keyed by the domain asked, before trimmed
+	key := domain
+
 	// break deadloop, for ugly wildcard setting dns setting
-	domain := strings.TrimSuffix(key.(string), ".")
+	domain = strings.TrimSuffix(domain, ".")
 	if strings.Count(domain, ".") > 10 {
-		return nil
-	}
-
-	wg := sync.WaitGroup{}
-	httpScore, httpsScore := new(int32), new(int32)
-	for _, ping := range [...]dynamic{{port: http.HTTP}, {port: http.HTTPS}} {
-		wg.Add(1)
-		go func(ping dynamic) {
-			defer wg.Done()
-
-			if err := ping.port.Ping(domain, timeout); err != nil {
-				return
-			}
-
-			switch ping.port {
-			case http.HTTP:
-				if !atomic.CompareAndSwapInt32(httpScore, 0, 2) {
-					atomic.AddInt32(httpScore, 1)
-				}
-			case http.HTTPS:
-				if !atomic.CompareAndSwapInt32(httpsScore, 0, 2) {
-					atomic.AddInt32(httpScore, 1)
-				}
-			}
-		}(ping)
-	}
-	for _, ping := range [...]dynamic{{port: http.HTTP}, {port: http.HTTPS}} {
-		wg.Add(1)
-		go func(ping dynamic) {
-			defer wg.Done()
-
-			var conn net.Conn
-			if addr, ok := socks5.IsSocks5Schema(Client.Address); ok {
-				conn, err = net.Dial("tcp", addr)
-				conn = socks5.ToSocks5(conn, domain, uint16(ping.port))
-
-			} else {
-				conn, err = tls.Dial("tcp", net.JoinHostPort(Client.Address, "443"), &tls.Config{})
-				if ping.port == http.HTTP {
-					conn = http.NewTgtConn(conn, passwordData, http.TGT_HTTP, "", 80)
-				} else {
-					conn = http.NewTgtConn(conn, passwordData, http.TGT_HTTPS, "", 443)
-				}
-			}
-			if err != nil {
-				log.Errorw("sower dial", "addr", Client.Address, "err", err)
-				return
-			}
-
-			if err := ping.port.PingWithConn(domain, conn, timeout); err != nil {
-				return
-			}
-
-			switch ping.port {
-			case http.HTTP:
-				if !atomic.CompareAndSwapInt32(httpScore, 0, -2) {
-					atomic.AddInt32(httpScore, -1)
-				}
-			case http.HTTPS:
-				if !atomic.CompareAndSwapInt32(httpsScore, 0, -2) {
-					atomic.AddInt32(httpScore, -1)
-				}
-			}
-		}(ping)
-	}
-
-	wg.Wait()
-	if int(*httpScore+*httpsScore)+conf.Client.Router.DetectLevel < 0 {
-		addDynamic(domain)
-		log.Infow("add rule", "domain", domain, "http_score", *httpScore, "https_score", *httpsScore)
-	}
-	return nil
+		verdicts.add(key)
+		return
+	}
+
+	score, scores := detectScores(domain)
+	if score+conf.Client.Router.DetectLevel < 0 {
+		addDynamic(domain, scores)
+		log.Infow("add rule", "domain", domain, "scores", scores)
+		return
+	}
+	// only the direct verdict is cached, the proxied ones are in dynamic list
+	verdicts.add(key)
 }
 
 // addDynamic add new domain into dynamic list
-func addDynamic(domain string) {
+func addDynamic(domain string, scores map[string]int) {
+	now := time.Now()
 	flushMu.Lock()
-	Client.Router.DynamicList = util.NewReverseSecSlice(
-		append(Client.Router.DynamicList, domain)).Sort().Uniq()
-	Client.Router.dynamicRules = util.NewNodeFromRules(Client.Router.DynamicList...)
+	insertDynamic(&dynamicEntry{
+		Domain:     domain,
+		LearnedAt:  now,
+		VerifiedAt: now,
+		Scores:     scores,
+	})
 	flushMu.Unlock()
 
+	triggerFlush()
+}
+
+// insertDynamic should be called with flushMu locked
+func insertDynamic(e *dynamicEntry) {
+	Client.Router.DynamicHistory = append(Client.Router.DynamicHistory, e)
+	count := len(Client.Router.DynamicHistory)
+	evictDynamic()
+	if len(Client.Router.DynamicHistory) == count {
+		// insert by copy on write, instead of rebuilding all
+		Client.Router.DynamicList = util.NewReverseSecSlice(append(Client.Router.DynamicList, e.Domain)).Sort().Uniq()
+		loaded().dynamicRules.Add(e.Domain)
+	} else {
+		setDynamic()
+	}
+}
+
+func triggerFlush() {
 	flushOnce.Do(func() {
 		if conf.file != "" {
 			go flushConf()
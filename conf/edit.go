@@ -0,0 +1,158 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/wweir/sower/util"
+	"github.com/wweir/utils/log"
+)
+
+// EditRule move the rule from a list to another at runtime, remove it if to is empty,
+// add it if from is empty. Lists are direct / proxy / dynamic / block, the change is
+// persisted into config file, and the cached verdicts of the names it affects are dropped.
+func EditRule(rule, from, to string) error {
+	rule = strings.TrimSuffix(strings.TrimSpace(rule), ".")
+	switch {
+	case rule == "":
+		return errors.New("empty rule")
+	case from == to:
+		return errors.New("the same list to move from and to")
+	}
+	for _, list := range []string{from, to} {
+		switch list {
+		case "", "direct", "proxy", "dynamic", "block":
+		default:
+			return fmt.Errorf("unknown list %q", list)
+		}
+	}
+
+	flushMu.Lock()
+	if from != "" && !removeRule(rule, from) {
+		flushMu.Unlock()
+		return fmt.Errorf("rule %s not found in %s list", rule, from)
+	}
+	if to != "" {
+		addRule(rule, to)
+	}
+	flushMu.Unlock()
+
+	invalidate(rule)
+	log.Infow("edit rule", "rule", rule, "from", from, "to", to)
+	triggerFlush()
+	return nil
+}
+
+// removeRule should be called with flushMu locked
+func removeRule(rule, list string) bool {
+	m := loaded()
+	switch list {
+	case "direct":
+		return removeFromList(&Client.Router.DirectList, m.directRules, rule)
+	case "proxy":
+		return removeFromList(&Client.Router.ProxyList, m.proxyRules, rule)
+	case "block":
+		// the rule may be in block_files too, rebuild from both
+		if !removeFromList(&Client.Router.BlockList, nil, rule) {
+			return false
+		}
+		m.blockRules.Replace(m.blockList()...)
+		return true
+	case "dynamic":
+		mergeDynamicHits()
+		entries := make([]*dynamicEntry, 0, len(Client.Router.DynamicHistory))
+		for _, e := range Client.Router.DynamicHistory {
+			if e.Domain != rule {
+				entries = append(entries, e)
+			}
+		}
+		Client.Router.DynamicHistory = entries
+		return removeFromList(&Client.Router.DynamicList, m.dynamicRules, rule)
+	}
+	return false
+}
+
+// removeFromList drop the rule from list into a new slice, and from node if not nil
+func removeFromList(list *[]string, node *util.Node, rule string) (found bool) {
+	out := make([]string, 0, len(*list))
+	for _, item := range *list {
+		if item == rule {
+			found = true
+		} else {
+			out = append(out, item)
+		}
+	}
+	*list = out
+
+	if found && node != nil {
+		node.Remove(rule)
+	}
+	return found
+}
+
+// addRule should be called with flushMu locked
+func addRule(rule, list string) {
+	add := func(list *[]string, node *util.Node) {
+		for _, item := range *list {
+			if item == rule {
+				return
+			}
+		}
+		*list = append(*list, rule)
+		node.Add(rule)
+	}
+
+	m := loaded()
+	switch list {
+	case "direct":
+		add(&Client.Router.DirectList, m.directRules)
+	case "proxy":
+		add(&Client.Router.ProxyList, m.proxyRules)
+	case "block":
+		add(&Client.Router.BlockList, m.blockRules)
+	case "dynamic":
+		now := time.Now()
+		insertDynamic(&dynamicEntry{Domain: rule, LearnedAt: now, VerifiedAt: now})
+	}
+}
+
+var dnsPurge atomic.Value
+
+// SetDNSPurge set the func dropping the cached dns answers of the domains matched, for the rules edited
+func SetDNSPurge(purge func(match func(domain string) bool)) {
+	dnsPurge.Store(purge)
+}
+
+// invalidate drop the cached verdicts and dns answers of the names matching the rule
+func invalidate(rule string) {
+	node := util.NewNodeFromRules(rule)
+	ipVerdicts.forget(node.Match)
+	if purge, ok := dnsPurge.Load().(func(func(string) bool)); ok {
+		purge(node.Match)
+	}
+
+	verdicts.Lock()
+	for domain := range verdicts.m {
+		if node.Match(domain) {
+			delete(verdicts.m, domain)
+			verdicts.dirty = true
+		}
+	}
+	verdicts.Unlock()
+
+	failuresMu.Lock()
+	for domain := range tempProxy {
+		if node.Match(domain) {
+			delete(tempProxy, domain)
+		}
+	}
+	for domain := range failures {
+		if node.Match(domain) {
+			delete(failures, domain)
+		}
+	}
+	failuresMu.Unlock()
+}
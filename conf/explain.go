@@ -0,0 +1,149 @@
+package conf
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/wweir/sower/internal/detect"
+)
+
+// Explain print how the target, a domain or an url, is routed for the client and why.
+// The domain is detected again without changing any rule if detectNow is set.
+func Explain(out io.Writer, inbound, client, target string, detectNow bool) error {
+	var clientIP net.IP
+	if client != "" {
+		if clientIP = net.ParseIP(client); clientIP == nil {
+			return fmt.Errorf("invalid client ip %q", client)
+		}
+	}
+
+	host, port := target, uint16(0)
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return err
+		}
+
+		host = u.Hostname()
+		switch {
+		case u.Port() != "":
+			p, err := strconv.ParseUint(u.Port(), 10, 16)
+			if err != nil {
+				return err
+			}
+			port = uint16(p)
+		case u.Scheme == "https":
+			port = 443
+		case u.Scheme == "http":
+			port = 80
+		}
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return fmt.Errorf("no domain in %q", target)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "domain\t%s\n", host)
+	if port != 0 {
+		fmt.Fprintf(w, "port\t%d\n", port)
+	}
+
+	routed := false
+	if policy := matchPolicy(clientIP); policy != nil {
+		if outbound, list, rule, ok := policy.route(host); ok {
+			fmt.Fprintf(w, "route\t%s, by %s %s\n", outbound, list, rule)
+			routed = true
+		}
+	}
+
+	for i, rule := range loaded().rules {
+		if rule.match(inbound, host, port, func() []net.IP { ips, _ := Resolve(host); return ips }) {
+			fmt.Fprintf(w, "%s\t%s, by client.router.rules #%d %v\n", fallback(routed), rule.outbound, i+1, rule.desc)
+			routed = true
+			break
+		}
+	}
+
+	if list, rule := matchBlock(host); list != "" {
+		fmt.Fprintf(w, "%s\tblock, by %s %s\n", fallback(routed), list, rule)
+		routed = true
+	}
+
+	proxy, list, rule := matchProxy(host)
+	switch {
+	case list != "":
+		fmt.Fprintf(w, "%s\t%s, by %s %s\n", fallback(routed), outbound(proxy), list, rule)
+	case verdicts.detected(host):
+		verdicts.Lock()
+		expire := verdicts.m[host]
+		verdicts.Unlock()
+		fmt.Fprintf(w, "%s\tdirect, by cached detection until %s\n", fallback(routed), expire.Format(time.RFC3339))
+	default:
+		fmt.Fprintf(w, "%s\tdirect, not detected yet, detect on the first request\n", fallback(routed))
+	}
+
+	if list == "dynamic_list" {
+		flushMu.Lock()
+		for _, e := range Client.Router.DynamicHistory {
+			if e.Domain == rule {
+				fmt.Fprintf(w, "learned\t%s\n", e.LearnedAt.Format(time.RFC3339))
+				fmt.Fprintf(w, "verified\t%s\n", e.VerifiedAt.Format(time.RFC3339))
+				if !e.LastHit.IsZero() {
+					fmt.Fprintf(w, "last hit\t%s\n", e.LastHit.Format(time.RFC3339))
+				}
+				fmt.Fprintf(w, "scores\t%s\n", formatScores(e.Scores))
+			}
+		}
+		flushMu.Unlock()
+	}
+
+	if detectNow {
+		detectors, err := newDetectors()
+		if err != nil {
+			return err
+		}
+		score, scores := detect.Detect(host, detectors...)
+		fmt.Fprintf(w, "detect\t%s\n", formatScores(scores))
+		fmt.Fprintf(w, "verdict\t%s, score %d with detect_level %d\n",
+			outbound(score+Client.Router.DetectLevel < 0), score, Client.Router.DetectLevel)
+	}
+	return nil
+}
+
+// fallback label the lists checked after a rule matched
+func fallback(routed bool) string {
+	if routed {
+		return "shadowed"
+	}
+	return "route"
+}
+
+func outbound(proxy bool) string {
+	if proxy {
+		return OutboundProxy
+	}
+	return OutboundDirect
+}
+
+func formatScores(scores map[string]int) string {
+	names := make([]string, 0, len(scores))
+	for name := range scores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		out = append(out, name+"="+strconv.Itoa(scores[name]))
+	}
+	return strings.Join(out, " ")
+}
@@ -0,0 +1,103 @@
+package conf
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wweir/utils/log"
+)
+
+// directFailure count the recent failures of the direct connections to a domain
+type directFailure struct {
+	count int
+	since time.Time
+}
+
+const (
+	failWindow = 10 * time.Minute
+	failSize   = 4096
+)
+
+var (
+	failuresMu = sync.Mutex{} // protect failures and tempProxy
+	failures   = map[string]*directFailure{}
+	tempProxy  = map[string]time.Time{} // domain -> expire time
+)
+
+// ReportDirect feed the outcome of a direct connection back to router. Repeated failures
+// within 10 minutes route the domain though proxy for fail_proxy_ttl, and trigger detection.
+func ReportDirect(domain string, failed bool) {
+	domain = strings.TrimSuffix(domain, ".")
+	now := time.Now()
+
+	failuresMu.Lock()
+	if !failed {
+		delete(failures, domain)
+		failuresMu.Unlock()
+		return
+	}
+
+	f, ok := failures[domain]
+	if !ok || now.Sub(f.since) > failWindow {
+		if len(failures) >= failSize {
+			for d, f := range failures {
+				if now.Sub(f.since) > failWindow {
+					delete(failures, d)
+				}
+			}
+		}
+		f = &directFailure{since: now}
+		failures[domain] = f
+	}
+	f.count++
+
+	threshold := Client.Router.FailThreshold
+	if threshold <= 0 {
+		threshold = 3
+	}
+	if f.count < threshold {
+		failuresMu.Unlock()
+		return
+	}
+	delete(failures, domain)
+
+	ttl := loaded().failProxyTTL
+	if ttl <= 0 {
+		ttl = 30 * time.Minute
+	}
+	if _, ok := tempProxy[domain]; !ok && len(tempProxy) >= failSize {
+		victim := ""
+		for d, expire := range tempProxy {
+			if expire.Before(now) {
+				delete(tempProxy, d)
+			} else if victim == "" || expire.Before(tempProxy[victim]) {
+				victim = d
+			}
+		}
+		if len(tempProxy) >= failSize {
+			delete(tempProxy, victim)
+		}
+	}
+	tempProxy[domain] = now.Add(ttl)
+	failuresMu.Unlock()
+	log.Infow("proxy temporarily", "domain", domain, "failures", threshold, "ttl", ttl)
+
+	verdicts.forget(domain)
+	if detecting != nil {
+		detecting.Do(domain, false)
+	}
+}
+
+// proxyTemporarily check if the domain failed directly recently
+func proxyTemporarily(domain string) bool {
+	failuresMu.Lock()
+	defer failuresMu.Unlock()
+
+	expire, ok := tempProxy[domain]
+	if ok && !time.Now().Before(expire) {
+		delete(tempProxy, domain)
+		return false
+	}
+	return ok
+}
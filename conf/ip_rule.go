@@ -0,0 +1,194 @@
+package conf
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wweir/sower/util"
+	"github.com/wweir/utils/log"
+)
+
+// reservedCIDRs go direct by default, unless matched by proxy_cidr or geoip rules
+var reservedCIDRs = []string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15",
+	"198.51.100.0/24", "203.0.113.0/24", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "64:ff9b:1::/48", "2001:db8::/32", "fc00::/7", "fe80::/10", "ff00::/8",
+}
+var reservedRules, _ = util.NewIPNodeFromCIDRs(false, reservedCIDRs...)
+
+var resolver atomic.Value
+
+// SetResolver replace the resolver of ip rules, eg: the dns relay bypass itself
+func SetResolver(resolve func(domain string) ([]net.IP, error)) {
+	resolver.Store(resolve)
+}
+
+// Resolve lookup the ips of domain, bypass the dns relay if started
+func Resolve(domain string) ([]net.IP, error) {
+	if resolve, ok := resolver.Load().(func(string) ([]net.IP, error)); ok {
+		return resolve(domain)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), domain)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+type ipVerdict struct {
+	proxy, ok bool
+	rule      string
+	expire    time.Time
+}
+
+// ipVerdictCache cache the verdicts of resolved domains, evict the soonest expiring ones once full
+type ipVerdictCache struct {
+	sync.Mutex
+	m map[string]*ipVerdict
+}
+
+var ipVerdicts = &ipVerdictCache{m: map[string]*ipVerdict{}}
+
+const (
+	ipVerdictTTL  = 10 * time.Minute
+	ipVerdictSize = 4096
+)
+
+func (c *ipVerdictCache) get(domain string) (*ipVerdict, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	v, ok := c.m[domain]
+	if ok && !time.Now().Before(v.expire) {
+		delete(c.m, domain)
+		return nil, false
+	}
+	return v, ok
+}
+
+func (c *ipVerdictCache) set(domain string, v *ipVerdict) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.m[domain]; !ok && len(c.m) >= ipVerdictSize {
+		now, victim := time.Now(), ""
+		for d, e := range c.m {
+			if e.expire.Before(now) {
+				delete(c.m, d)
+			} else if victim == "" || e.expire.Before(c.m[victim].expire) {
+				victim = d
+			}
+		}
+		if len(c.m) >= ipVerdictSize {
+			delete(c.m, victim)
+		}
+	}
+	c.m[domain] = v
+}
+
+// forget drop the verdicts of the domains matched, all if match is nil
+func (c *ipVerdictCache) forget(match func(domain string) bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	for domain := range c.m {
+		if match == nil || match(domain) {
+			delete(c.m, domain)
+		}
+	}
+}
+
+// cidrRule is the value of the cidr rules tree
+type cidrRule struct {
+	proxy bool
+	rule  string // eg: direct_cidr 10.0.0.0/8
+}
+
+// RouteIP check the ip by proxy_cidr / direct_cidr and geoip rules, ok is false if no rule matched
+func RouteIP(ip net.IP) (proxy, ok bool) {
+	proxy, _, ok = matchIP(ip)
+	return proxy, ok
+}
+
+// matchIP return the rule deciding whether ip goes though proxy, ok is false if no rule matched
+func matchIP(ip net.IP) (proxy bool, rule string, ok bool) {
+	m := loaded()
+	if val, ok := m.cidrRules.Lookup(ip); ok {
+		r := val.(*cidrRule)
+		return r.proxy, r.rule, true
+	}
+
+	if geo := m.geoip; geo != nil {
+		country, err := geo.Country(ip)
+		if err != nil {
+			log.Errorw("lookup geoip", "ip", ip, "err", err)
+			return false, "", false
+		}
+		if country == "" {
+			return false, "", false
+		}
+		if containsFold(m.directCountry, country) {
+			return false, "direct_country " + country, true
+		}
+		if containsFold(m.proxyCountry, country) {
+			return true, "proxy_country " + country, true
+		}
+	}
+	return false, "", false
+}
+
+// routeByIP route the ip, or the ips the domain resolved to, by the ip rules.
+// Domains are resolved only if any cidr or geoip rule configured.
+func routeByIP(domain string) (proxy bool, rule string, ok bool) {
+	if ip := net.ParseIP(domain); ip != nil {
+		return routeIPs(ip)
+	}
+	if !loaded().ipRules {
+		return false, "", false
+	}
+
+	if v, ok := ipVerdicts.get(domain); ok {
+		return v.proxy, v.rule, v.ok
+	}
+
+	// unresolvable domains are left to dynamic detection
+	if ips, err := Resolve(domain); err == nil {
+		proxy, rule, ok = routeIPs(ips...)
+	}
+	ipVerdicts.set(domain, &ipVerdict{proxy, ok, rule, time.Now().Add(ipVerdictTTL)})
+	return proxy, rule, ok
+}
+
+func routeIPs(ips ...net.IP) (proxy bool, rule string, ok bool) {
+	for _, ip := range ips {
+		if proxy, rule, ok := matchIP(ip); ok {
+			return proxy, ip.String() + " in " + rule, true
+		}
+	}
+	for _, ip := range ips {
+		if reservedRules.Match(ip) {
+			return false, ip.String() + " in reserved cidrs", true
+		}
+	}
+	return false, "", false
+}
+
+func containsFold(list []string, s string) bool {
+	for i := range list {
+		if strings.EqualFold(list[i], s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package conf
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/wweir/sower/internal/geoip"
+	"github.com/wweir/sower/util"
+)
+
+// matchers is compiled from the config by load_rules, and published as a whole,
+// so the lookups in flight never see a half loaded one
+type matchers struct {
+	// the suffix rules are changed at runtime, eg: by dynamic detection or edit api,
+	// with flushMu locked
+	directRules  *util.Node
+	proxyRules   *util.Node
+	blockRules   *util.Node // block_list and blockFiles
+	dynamicRules *util.Node
+	blockFiles   []string // the rules parsed from block_files
+
+	proxyKeywords  *util.KeywordMatcher
+	directKeywords *util.KeywordMatcher
+	proxyKeyword   []string // the rules of proxyKeywords by id
+	directKeyword  []string
+	proxyRegexes   *util.RegexMatcher
+	directRegexes  *util.RegexMatcher
+
+	cidrRules     *util.IPNode
+	geoip         *geoip.Reader
+	geoipFile     string // the path geoip opened from
+	proxyCountry  []string
+	directCountry []string
+	ipRules       bool // any cidr or geoip rule configured
+
+	providers    []*provider
+	rules        []*routeRule
+	clients      *util.IPNode
+	failProxyTTL time.Duration
+}
+
+var compiled atomic.Value // *matchers
+
+// loaded return the matchers published last, empty ones before config loaded
+func loaded() *matchers {
+	if m, ok := compiled.Load().(*matchers); ok {
+		return m
+	}
+	return &matchers{}
+}
+
+// blockList merge block_list and the rules of block_files, should be called with flushMu locked
+func (m *matchers) blockList() []string {
+	return append(append([]string{}, Client.Router.BlockList...), m.blockFiles...)
+}
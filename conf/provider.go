@@ -0,0 +1,158 @@
+package conf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/wweir/sower/internal/rule"
+	"github.com/wweir/utils/log"
+)
+
+type provider struct {
+	*rule.Provider
+	list string
+}
+
+var dialer atomic.Value
+
+// SetDialer set the dialer though proxy, for the providers fetch though proxy
+func SetDialer(dial func(network, addr string) (net.Conn, error)) {
+	dialer.Store(dial)
+}
+
+var proxyClient = &http.Client{
+	Timeout: time.Minute,
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dial, ok := dialer.Load().(func(network, addr string) (net.Conn, error))
+			if !ok {
+				return nil, errors.New("proxy not started")
+			}
+			return dial(network, addr)
+		},
+	},
+}
+
+var directClient = &http.Client{Timeout: time.Minute}
+
+// providerStop stop the refresh loops of the providers loaded last time
+var providerStop = make(chan struct{})
+
+// providerConf is the providers config loaded last time
+var providerConf string
+
+// providerFetched flush the rules once an url provider fetched first,
+// set in init for the initialization cycle though loadConfigFns
+var providerFetched = func() {}
+
+// loadProviders load the providers, and refresh them periodically.
+// The last ones are kept if the config is not changed, eg: reloaded after flush.
+func loadProviders(last []*provider) (providers []*provider, err error) {
+	config := fmt.Sprintf("%+v", Client.Router.Providers)
+	if config == providerConf {
+		return last, nil
+	}
+
+	providers = make([]*provider, 0, len(Client.Router.Providers))
+	stop := make(chan struct{})
+	defer func() {
+		if err != nil {
+			close(stop) // the refresh loops started
+		}
+	}()
+	for _, p := range Client.Router.Providers {
+		switch p.List {
+		case "proxy", "direct", "block":
+		default:
+			return nil, fmt.Errorf("provider %s%s: unknown list %q", p.File, p.URL, p.List)
+		}
+
+		source, client, interval := p.File, directClient, time.Duration(0)
+		if p.URL != "" {
+			source, interval = p.URL, 24*time.Hour
+		}
+		if p.Proxy {
+			client = proxyClient
+		}
+		if p.Interval != "" {
+			var err error
+			if interval, err = time.ParseDuration(p.Interval); err != nil {
+				return nil, fmt.Errorf("provider %s: %w", source, err)
+			}
+		}
+
+		// url source starts from its cache or empty, never blocks the start up,
+		// and is fetched in background soon, eg: after proxy started
+		prov, next, loaded := &provider{rule.NewProvider(source, p.Format, p.Cache, client), p.List}, interval, true
+		var bad []string
+		if p.URL == "" {
+			bad, err = prov.Load()
+		} else if bad, err = prov.LoadCache(); err == nil {
+			next = 10 * time.Second
+		} else {
+			next, loaded, err = 0, false, nil
+		}
+		if err != nil {
+			log.Errorw("load provider, retry later", "source", source, "err", err)
+			next, loaded, err = 10*time.Second, false, nil
+		} else if len(bad) != 0 {
+			log.Infow("skip untranslatable rules", "source", source, "count", len(bad), "first", bad[0])
+		}
+		providers = append(providers, prov)
+
+		// never fetch or write the cache while inspecting
+		if !inspecting && (interval > 0 || !loaded) {
+			go refreshProvider(prov, next, interval, loaded, stop)
+		}
+	}
+
+	close(providerStop)
+	providerStop, providerConf = stop, config
+	return providers, nil
+}
+
+// refreshProvider refresh the provider periodically, the first rules fetched are routed by a flush,
+// interval 0 to refresh until the first success only
+func refreshProvider(p *provider, next, interval time.Duration, loaded bool, stop <-chan struct{}) {
+	for {
+		timer := time.NewTimer(next)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if _, err := p.Refresh(); err != nil {
+			log.Errorw("refresh provider, keep the last rules", "source", p.Source, "err", err)
+			if next = time.Minute; 0 < interval && interval < next {
+				next = interval
+			}
+			continue
+		}
+
+		if !loaded {
+			loaded = true
+			providerFetched()
+		}
+		if interval <= 0 {
+			return
+		}
+		next = interval
+	}
+}
+
+// matchProviders return the source of the provider in list matching the domain
+func matchProviders(list, domain string) (source string, ok bool) {
+	for _, p := range loaded().providers {
+		if p.list == list && p.Match(domain) {
+			return p.Source, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,150 @@
+package conf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/wweir/sower/util"
+)
+
+// Outbounds built in, the others are configured in client.outbounds
+const (
+	OutboundDirect = "direct"
+	OutboundBlock  = "block"
+	OutboundProxy  = "proxy" // client.address
+)
+
+// Inbounds the traffic comes from
+const (
+	InboundHTTPProxy   = "http_proxy"
+	InboundDNS         = "dns" // dns relay, and the traffic to serve_ip it leads to
+	InboundPortMapping = "port_mapping"
+	InboundFakeIP      = "fake_ip"
+)
+
+type routeRule struct {
+	desc     []string // the domains configured, for explain
+	domains  *util.Node
+	cidrs    *util.IPNode
+	ports    map[uint16]bool
+	inbounds map[string]bool
+	outbound string
+}
+
+func loadRoutes() ([]*routeRule, error) {
+	rules := make([]*routeRule, 0, len(Client.Router.Rules))
+	for i, r := range Client.Router.Rules {
+		rule := &routeRule{desc: append([]string{}, r.Domains...), outbound: r.Outbound}
+		switch r.Outbound {
+		case OutboundDirect, OutboundBlock, OutboundProxy:
+		default:
+			if _, ok := Client.Outbounds[r.Outbound]; !ok {
+				return nil, fmt.Errorf("rule %d: outbound %q not configured", i, r.Outbound)
+			}
+		}
+
+		if len(r.Domains) != 0 {
+			rule.domains = util.NewNodeFromRules(r.Domains...)
+		}
+		if len(r.CIDRs) != 0 {
+			cidrs, err := util.NewIPNodeFromCIDRs(true, r.CIDRs...)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+			rule.cidrs = cidrs
+		}
+		if len(r.Ports) != 0 {
+			rule.ports = map[uint16]bool{}
+			for _, port := range r.Ports {
+				rule.ports[port] = true
+			}
+		}
+		if len(r.Inbounds) != 0 {
+			rule.inbounds = map[string]bool{}
+			for _, inbound := range r.Inbounds {
+				rule.inbounds[inbound] = true
+			}
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// match check if all the matchers set match, ips are resolved on demand
+func (r *routeRule) match(inbound, host string, port uint16, ips func() []net.IP) bool {
+	if r.inbounds != nil && !r.inbounds[inbound] {
+		return false
+	}
+	if r.ports != nil && !r.ports[port] {
+		return false // never match dns queries, the port is unknown
+	}
+	if r.domains != nil && !r.domains.Match(host) {
+		return false
+	}
+	if r.cidrs != nil {
+		for _, ip := range ips() {
+			if r.cidrs.Match(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Route pick the outbound for the traffic from client to host:port by the policy of the client,
+// the ordered rules, and fallback to the block / proxy / direct lists.
+// Port is 0 for dns queries, client is nil if unknown.
+func Route(inbound string, client net.IP, host string, port uint16) string {
+	host = strings.TrimSuffix(host, ".")
+	if policy := matchPolicy(client); policy != nil {
+		if outbound, _, _, ok := policy.route(host); ok {
+			return outbound
+		}
+	}
+
+	var resolved []net.IP
+	ips := func() []net.IP {
+		if resolved == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				resolved = []net.IP{ip}
+			} else if resolved, _ = Resolve(host); resolved == nil {
+				resolved = []net.IP{}
+			}
+		}
+		return resolved
+	}
+
+	for _, rule := range loaded().rules {
+		if rule.match(inbound, host, port, ips) {
+			return rule.outbound
+		}
+	}
+
+	switch {
+	case ShouldBlock(host):
+		return OutboundBlock
+	case ShouldProxy(host):
+		return OutboundProxy
+	default:
+		return OutboundDirect
+	}
+}
+
+// Outbound return the address and password of a proxy outbound
+func Outbound(name string) (address, password string, ok bool) {
+	if name == OutboundProxy {
+		return Client.Address, Password, true
+	}
+
+	out, ok := Client.Outbounds[name]
+	if !ok {
+		return "", "", false
+	}
+	if out.Password == "" {
+		return out.Address, Password, true
+	}
+	return out.Address, out.Password, true
+}
@@ -0,0 +1,116 @@
+package conf
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// verdict is a negative detection result, the domain goes direct until expire
+type verdict struct {
+	Domain string    `toml:"domain"`
+	Expire time.Time `toml:"expire"`
+}
+
+// verdictCache remember the detected domains, evict the soonest expiring ones once full
+type verdictCache struct {
+	sync.Mutex
+	ttl   time.Duration
+	size  int
+	m     map[string]time.Time
+	dirty bool
+}
+
+var verdicts = &verdictCache{ttl: 2 * time.Hour, size: 4096, m: map[string]time.Time{}}
+
+// detected check if the domain is detected within ttl
+func (c *verdictCache) detected(domain string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	expire, ok := c.m[domain]
+	return ok && time.Now().Before(expire)
+}
+
+// add remember the domain is detected now
+func (c *verdictCache) add(domain string) {
+	c.Lock()
+	c.set(domain, time.Now().Add(c.ttl))
+	c.Unlock()
+}
+
+// forget drop the verdict of the domain, detect it again next time
+func (c *verdictCache) forget(domain string) {
+	c.Lock()
+	if _, ok := c.m[domain]; ok {
+		delete(c.m, domain)
+		c.dirty = true
+	}
+	c.Unlock()
+}
+
+// set should be called with lock held
+func (c *verdictCache) set(domain string, expire time.Time) {
+	if _, ok := c.m[domain]; !ok && c.size > 0 && len(c.m) >= c.size {
+		now, victim := time.Now(), ""
+		for d, e := range c.m {
+			if e.Before(now) {
+				delete(c.m, d)
+			} else if victim == "" || e.Before(c.m[victim]) {
+				victim = d
+			}
+		}
+		if len(c.m) >= c.size {
+			delete(c.m, victim)
+		}
+	}
+
+	c.m[domain] = expire
+	c.dirty = true
+}
+
+// reset apply the ttl and size, and load the persisted verdicts not expired
+func (c *verdictCache) reset(ttl time.Duration, size int, persisted []*verdict) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.ttl, c.size = ttl, size
+	now := time.Now()
+	for _, v := range persisted {
+		if _, ok := c.m[v.Domain]; !ok && now.Before(v.Expire) {
+			c.set(v.Domain, v.Expire)
+		}
+	}
+	c.dirty = false
+}
+
+// dump return the verdicts not expired sorted by domain, excluding the domains in dynamic rules.
+// The config file is kept in stable order across flushes.
+func (c *verdictCache) dump() []*verdict {
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	out := make([]*verdict, 0, len(c.m))
+	for domain, expire := range c.m {
+		if now.Before(expire) && !loaded().dynamicRules.Match(domain) {
+			out = append(out, &verdict{domain, expire.Truncate(time.Second)})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Domain < out[j].Domain })
+	c.dirty = false
+	return out
+}
+
+// flushVerdicts persist the new verdicts periodically
+func flushVerdicts() {
+	for range time.Tick(10 * time.Minute) {
+		verdicts.Lock()
+		dirty := verdicts.dirty
+		verdicts.Unlock()
+
+		if dirty {
+			triggerFlush()
+		}
+	}
+}
@@ -10,5 +10,6 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/wweir/utils v0.0.0-20200214114658-f6f356a08736
 	golang.org/x/crypto v0.0.0-20200214034016-1d94cc7ab1c6
+	golang.org/x/net v0.0.0-20190923162816-aa69164e4478
 	golang.org/x/sys v0.0.0-20200212091648-12a6c2dcc1e4
 )
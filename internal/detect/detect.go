@@ -0,0 +1,191 @@
+// Package detect score domains by probing them directly and though proxy
+package detect
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	_dns "github.com/wweir/sower/internal/dns"
+)
+
+// Dialer dial a tcp addr in host:port, directly or though proxy
+type Dialer func(addr string, timeout time.Duration) (net.Conn, error)
+
+// DirectDialer dial addr directly
+func DirectDialer(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, timeout)
+}
+
+// Detector score a domain, positive if direct is fine, negative if proxy is required
+type Detector interface {
+	Name() string
+	Detect(domain string) int
+}
+
+// Probe is the common setting of the detectors probing directly and though proxy.
+// Weight is added if direct works, and subtracted if proxy works.
+type Probe struct {
+	Port    uint16
+	Weight  int
+	Timeout time.Duration
+	Direct  Dialer
+	Proxy   Dialer
+}
+
+func (p *Probe) name(typ string) string {
+	return typ + "_" + strconv.Itoa(int(p.Port))
+}
+
+func (p *Probe) score(domain string, probe func(conn net.Conn) error) int {
+	run := func(dial Dialer) bool {
+		if dial == nil {
+			return false
+		}
+		conn, err := dial(net.JoinHostPort(domain, strconv.Itoa(int(p.Port))), p.Timeout)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(p.Timeout))
+		return probe(conn) == nil
+	}
+
+	var direct, proxy bool
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() { defer wg.Done(); direct = run(p.Direct) }()
+	go func() { defer wg.Done(); proxy = run(p.Proxy) }()
+	wg.Wait()
+
+	score := 0
+	if direct {
+		score += p.Weight
+	}
+	if proxy {
+		score -= p.Weight
+	}
+	return score
+}
+
+// TCP check if the tcp connection could be established
+type TCP struct{ Probe }
+
+func (d *TCP) Name() string { return d.name("tcp") }
+
+func (d *TCP) Detect(domain string) int {
+	return d.score(domain, func(net.Conn) error { return nil })
+}
+
+// TLS check if a full handshake succeed with the certificate verified,
+// the reset or man-in-the-middle attacks fail it
+type TLS struct {
+	Probe
+	RootCAs *x509.CertPool // system pool if nil
+}
+
+func (d *TLS) Name() string { return d.name("tls") }
+
+func (d *TLS) Detect(domain string) int {
+	return d.score(domain, func(conn net.Conn) error {
+		return tls.Client(conn, &tls.Config{ServerName: domain, RootCAs: d.RootCAs}).Handshake()
+	})
+}
+
+// HTTP check if a response with status below 500 returned, over TLS on port 443
+type HTTP struct {
+	Probe
+	RootCAs *x509.CertPool
+}
+
+func (d *HTTP) Name() string { return d.name("http") }
+
+func (d *HTTP) Detect(domain string) int {
+	return d.score(domain, func(conn net.Conn) error {
+		if d.Port == 443 {
+			conn = tls.Client(conn, &tls.Config{ServerName: domain, RootCAs: d.RootCAs})
+		}
+
+		req, _ := http.NewRequest(http.MethodHead, "http://"+domain+"/", nil)
+		req.Header.Set("User-Agent", "sower")
+		if err := req.Write(conn); err != nil {
+			return err
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return &statusError{resp.StatusCode}
+		}
+		return nil
+	})
+}
+
+type statusError struct{ code int }
+
+func (e *statusError) Error() string { return "http status: " + strconv.Itoa(e.code) }
+
+// Exchange resolve a dns request
+type Exchange func(r *dns.Msg) (*dns.Msg, error)
+
+// DNS compare the local answer with the remote one resolved though tunnel,
+// subtract Weight if the local one looks poisoned, see _dns.Poisoned
+type DNS struct {
+	Weight int
+	Local  Exchange
+	Remote Exchange
+}
+
+func (d *DNS) Name() string { return "dns" }
+
+func (d *DNS) Detect(domain string) int {
+	if d.Local == nil || d.Remote == nil {
+		return 0
+	}
+
+	r := new(dns.Msg).SetQuestion(dns.Fqdn(domain), dns.TypeA)
+	local, err := d.Local(r)
+	if err != nil || len(_dns.AnswerIPs(local)) == 0 {
+		return 0
+	}
+	remote, err := d.Remote(r.Copy())
+	if err != nil || len(_dns.AnswerIPs(remote)) == 0 {
+		return 0
+	}
+
+	if !_dns.Poisoned(local, remote) {
+		return 0
+	}
+	return -d.Weight
+}
+
+// Detect run the detectors concurrently, return the total score and the ones of each detector
+func Detect(domain string, detectors ...Detector) (total int, scores map[string]int) {
+	scores = make(map[string]int, len(detectors))
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, d := range detectors {
+		wg.Add(1)
+		go func(d Detector) {
+			defer wg.Done()
+			score := d.Detect(domain)
+
+			mu.Lock()
+			scores[d.Name()] += score
+			total += score
+			mu.Unlock()
+		}(d)
+	}
+	wg.Wait()
+	return total, scores
+}
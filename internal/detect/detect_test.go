@@ -0,0 +1,155 @@
+package detect
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeDialer dial the listener whatever the addr is
+func fakeDialer(addr string) Dialer {
+	return func(string, time.Duration) (net.Conn, error) {
+		return net.Dial("tcp", addr)
+	}
+}
+
+func failDialer(string, time.Duration) (net.Conn, error) {
+	return nil, errors.New("connection reset")
+}
+
+func TestTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	ok := fakeDialer(ln.Addr().String())
+	tests := []struct {
+		name          string
+		direct, proxy Dialer
+		want          int
+	}{
+		{"both", ok, ok, 0},
+		{"direct", ok, failDialer, 2},
+		{"proxy", failDialer, ok, -2},
+		{"none", failDialer, failDialer, 0},
+		{"no proxy", ok, nil, 2},
+	}
+	for _, tt := range tests {
+		d := &TCP{Probe{Port: 80, Weight: 2, Timeout: time.Second, Direct: tt.direct, Proxy: tt.proxy}}
+		if got := d.Detect("example.com"); got != tt.want {
+			t.Errorf("TCP(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	// the self signed certificate of httptest is valid for example.com
+	dial := fakeDialer(srv.Listener.Addr().String())
+	d := &TLS{Probe: Probe{Port: 443, Weight: 3, Timeout: time.Second, Direct: dial, Proxy: failDialer}, RootCAs: pool}
+	if got := d.Detect("example.com"); got != 3 {
+		t.Errorf("TLS() = %d, want 3", got)
+	}
+	if got := d.Detect("mismatch.example.org"); got != 0 {
+		t.Errorf("TLS(name mismatch) = %d, want 0", got)
+	}
+
+	// man-in-the-middle on direct, the certificate is not trusted
+	d.RootCAs, d.Direct, d.Proxy = x509.NewCertPool(), dial, failDialer
+	if got := d.Detect("example.com"); got != 0 {
+		t.Errorf("TLS(untrusted) = %d, want 0", got)
+	}
+	if d.Name() != "tls_443" {
+		t.Errorf("Name() = %s", d.Name())
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Host, "bad.") {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	dial := fakeDialer(srv.Listener.Addr().String())
+	d := &HTTP{Probe: Probe{Port: 80, Weight: 1, Timeout: time.Second, Direct: failDialer, Proxy: dial}}
+	if got := d.Detect("example.com"); got != -1 {
+		t.Errorf("HTTP() = %d, want -1", got)
+	}
+	if got := d.Detect("bad.example.com"); got != 0 {
+		t.Errorf("HTTP(502) = %d, want 0", got)
+	}
+}
+
+func TestDNS(t *testing.T) {
+	answer := func(ip string) Exchange {
+		return func(r *dns.Msg) (*dns.Msg, error) {
+			msg := new(dns.Msg).SetReply(r)
+			msg.Answer = []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(ip),
+			}}
+			return msg, nil
+		}
+	}
+	fail := func(*dns.Msg) (*dns.Msg, error) { return nil, errors.New("timeout") }
+
+	tests := []struct {
+		name          string
+		local, remote Exchange
+		want          int
+	}{
+		{"same", answer("1.1.1.1"), answer("1.1.1.1"), 0},
+		{"poisoned", answer("10.10.10.10"), answer("1.1.1.1"), -2},
+		{"cdn", answer("2.2.2.2"), answer("1.1.1.1"), 0},
+		{"remote fail", answer("10.10.10.10"), fail, 0},
+		{"disabled", answer("10.10.10.10"), nil, 0},
+	}
+	for _, tt := range tests {
+		d := &DNS{Weight: 2, Local: tt.local, Remote: tt.remote}
+		if got := d.Detect("example.com"); got != tt.want {
+			t.Errorf("DNS(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+type fixed struct {
+	name  string
+	score int
+}
+
+func (f fixed) Name() string      { return f.name }
+func (f fixed) Detect(string) int { return f.score }
+
+func TestDetect(t *testing.T) {
+	total, scores := Detect("example.com", fixed{"http_80", -2}, fixed{"tls_443", -2}, fixed{"dns", 1})
+	if total != -3 {
+		t.Errorf("Detect() total = %d, want -3", total)
+	}
+	if scores["http_80"] != -2 || scores["tls_443"] != -2 || scores["dns"] != 1 || len(scores) != 3 {
+		t.Errorf("Detect() scores = %v", scores)
+	}
+}
@@ -0,0 +1,71 @@
+package detect
+
+import "sync"
+
+// Pool run the detections with limited workers and a bounded queue,
+// the concurrent detections of a domain are coalesced into one
+type Pool struct {
+	detect func(domain string)
+	queue  chan *call
+	mu     sync.Mutex
+	calls  map[string]*call
+}
+
+type call struct {
+	domain string
+	done   chan struct{}
+}
+
+// NewPool start workers to run detect, queue is the pending detections allowed
+func NewPool(workers, queue int, detect func(domain string)) *Pool {
+	p := &Pool{
+		detect: detect,
+		queue:  make(chan *call, queue),
+		calls:  map[string]*call{},
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *Pool) work() {
+	for c := range p.queue {
+		p.detect(c.domain)
+
+		p.mu.Lock()
+		delete(p.calls, c.domain)
+		p.mu.Unlock()
+		close(c.done)
+	}
+}
+
+// Do queue the detection of domain or join the in-flight one, and wait for it
+// to finish if wait is set. Return false if the queue is full.
+func (p *Pool) Do(domain string, wait bool) bool {
+	p.mu.Lock()
+	c, ok := p.calls[domain]
+	if !ok {
+		c = &call{domain: domain, done: make(chan struct{})}
+		select {
+		case p.queue <- c:
+			p.calls[domain] = c
+		default:
+			p.mu.Unlock()
+			return false
+		}
+	}
+	p.mu.Unlock()
+
+	if wait {
+		<-c.done
+	}
+	return true
+}
+
+// Pending return the count of the queued and running detections
+func (p *Pool) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.calls)
+}
@@ -0,0 +1,76 @@
+package detect
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolCoalesce(t *testing.T) {
+	var runs int32
+	release := make(chan struct{})
+	p := NewPool(2, 8, func(string) {
+		atomic.AddInt32(&runs, 1)
+		<-release
+	})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !p.Do("example.com", true) {
+				t.Error("Do() = false, want true")
+			}
+		}()
+	}
+	for p.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if runs != 1 {
+		t.Errorf("detected %d times, want 1", runs)
+	}
+	if p.Pending() != 0 {
+		t.Errorf("Pending() = %d, want 0", p.Pending())
+	}
+}
+
+func TestPoolBounded(t *testing.T) {
+	var running, max int32
+	release := make(chan struct{})
+	p := NewPool(2, 3, func(string) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+	})
+
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		if p.Do(strconv.Itoa(i)+".example.com", false) {
+			accepted++
+		}
+	}
+	// 2 running at most, 3 queued, the others are rejected
+	if accepted < 3 || accepted > 5 {
+		t.Errorf("accepted %d detections, want 3~5", accepted)
+	}
+
+	close(release)
+	for p.Pending() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if max > 2 {
+		t.Errorf("%d detections ran concurrently, want 2 at most", max)
+	}
+}
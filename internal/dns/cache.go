@@ -0,0 +1,211 @@
+package dns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// prefetchHits is the hit count an entry needs before it is refreshed ahead of expiry
+const prefetchHits = 3
+
+// Cache is a TTL aware LRU cache of dns responses.
+// The responses resolved remotely and locally are kept apart, as they may differ.
+type Cache struct {
+	hits     uint64 // 64-bit aligned for atomic on 32-bit platforms
+	misses   uint64
+	size     int
+	prefetch func(r *dns.Msg, remote bool)
+
+	sync.Mutex
+	ll    *list.List
+	items map[key]*list.Element
+}
+
+type key struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+	remote bool
+}
+
+type entry struct {
+	key
+	msg         *dns.Msg
+	ttl         uint32
+	stored      time.Time
+	expire      time.Time
+	hits        uint32
+	prefetching int32
+}
+
+// NewCache create a cache holding at most size responses.
+// Popular entries are handed to prefetch shortly before they expire, if set.
+func NewCache(size int, prefetch func(r *dns.Msg, remote bool)) *Cache {
+	return &Cache{
+		size:     size,
+		prefetch: prefetch,
+		ll:       list.New(),
+		items:    map[key]*list.Element{},
+	}
+}
+
+func newKey(q dns.Question, remote bool) key {
+	return key{strings.ToLower(q.Name), q.Qtype, q.Qclass, remote}
+}
+
+// Get return a copy of the cached response for the request with TTLs aged, or nil
+func (c *Cache) Get(r *dns.Msg, remote bool) *dns.Msg {
+	if c == nil || len(r.Question) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	c.Lock()
+	elem, ok := c.items[newKey(r.Question[0], remote)]
+	if !ok {
+		c.Unlock()
+		atomic.AddUint64(&c.misses, 1)
+		return nil
+	}
+	e := elem.Value.(*entry)
+	if !now.Before(e.expire) {
+		c.ll.Remove(elem)
+		delete(c.items, e.key)
+		c.Unlock()
+		atomic.AddUint64(&c.misses, 1)
+		return nil
+	}
+	c.ll.MoveToFront(elem)
+	e.hits++
+	hits := e.hits
+	msg := e.msg.Copy()
+	c.Unlock()
+	atomic.AddUint64(&c.hits, 1)
+
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+	for _, rrs := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range rrs {
+			if hdr := rr.Header(); hdr.Rrtype != dns.TypeOPT {
+				if hdr.Ttl > elapsed {
+					hdr.Ttl -= elapsed
+				} else {
+					hdr.Ttl = 0
+				}
+			}
+		}
+	}
+	msg.Id = r.Id
+	msg.Question = r.Question
+
+	// refresh popular entries in the last tenth of their lifetime
+	if c.prefetch != nil && hits >= prefetchHits &&
+		e.expire.Sub(now) < time.Duration(e.ttl)*time.Second/10 &&
+		atomic.CompareAndSwapInt32(&e.prefetching, 0, 1) {
+		go c.prefetch(r.Copy(), remote)
+	}
+	return msg
+}
+
+// Set cache the response until its TTL runs out.
+// Negative answers are cached with the SOA minimum, as RFC 2308 describes.
+func (c *Cache) Set(msg *dns.Msg, remote bool) {
+	if c == nil || msg == nil || msg.Truncated || len(msg.Question) == 0 {
+		return
+	}
+
+	ttl, ok := cacheTTL(msg)
+	if !ok || ttl == 0 {
+		return
+	}
+
+	now := time.Now()
+	e := &entry{
+		key:    newKey(msg.Question[0], remote),
+		msg:    msg.Copy(),
+		ttl:    ttl,
+		stored: now,
+		expire: now.Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if elem, ok := c.items[e.key]; ok {
+		elem.Value = e
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	c.items[e.key] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		elem := c.ll.Back()
+		c.ll.Remove(elem)
+		delete(c.items, elem.Value.(*entry).key)
+	}
+}
+
+func cacheTTL(msg *dns.Msg) (uint32, bool) {
+	switch {
+	case msg.Rcode == dns.RcodeNameError,
+		msg.Rcode == dns.RcodeSuccess && len(msg.Answer) == 0:
+		// negative answers without SOA must not be cached, RFC 2308 section 5
+		for _, rr := range msg.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				if soa.Minttl < soa.Hdr.Ttl {
+					return soa.Minttl, true
+				}
+				return soa.Hdr.Ttl, true
+			}
+		}
+		return 0, false
+
+	case msg.Rcode == dns.RcodeSuccess:
+		ttl := msg.Answer[0].Header().Ttl
+		for _, rr := range msg.Answer[1:] {
+			if rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+		return ttl, true
+
+	default:
+		return 0, false
+	}
+}
+
+// Purge drop the cached responses of the names matched, the name is without the trailing dot
+func (c *Cache) Purge(match func(name string) bool) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for k, elem := range c.items {
+		if match(strings.TrimSuffix(k.name, ".")) {
+			c.ll.Remove(elem)
+			delete(c.items, k)
+		}
+	}
+}
+
+// Len return the count of cached responses
+func (c *Cache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.ll.Len()
+}
+
+// Hits return the count of requests answered from cache
+func (c *Cache) Hits() uint64 {
+	return atomic.LoadUint64(&c.hits)
+}
+
+// Misses return the count of requests not found in cache
+func (c *Cache) Misses() uint64 {
+	return atomic.LoadUint64(&c.misses)
+}
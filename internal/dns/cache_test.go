@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func reply(name string, qtype uint16, rcode int, answer, ns []dns.RR) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m = new(dns.Msg).SetReply(m)
+	m.Rcode = rcode
+	m.Answer, m.Ns = answer, ns
+	return m
+}
+
+func TestCache(t *testing.T) {
+	a := func(name string, ttl uint32) dns.RR {
+		return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: net.IPv4(1, 2, 3, 4)}
+	}
+	soa := &dns.SOA{Hdr: dns.RR_Header{Name: "cc.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 600}, Minttl: 60}
+
+	tests := []struct {
+		name  string
+		msg   *dns.Msg
+		query string
+		want  bool
+	}{
+		{"positive", reply("wweir.cc.", dns.TypeA, dns.RcodeSuccess, []dns.RR{a("wweir.cc.", 60)}, nil), "wweir.cc.", true},
+		{"case_insensitive", reply("WWeir.cc.", dns.TypeA, dns.RcodeSuccess, []dns.RR{a("WWeir.cc.", 60)}, nil), "wweir.CC.", true},
+		{"zero_ttl", reply("wweir.cc.", dns.TypeA, dns.RcodeSuccess, []dns.RR{a("wweir.cc.", 0)}, nil), "wweir.cc.", false},
+		{"nxdomain_soa", reply("wweir.cc.", dns.TypeA, dns.RcodeNameError, nil, []dns.RR{soa}), "wweir.cc.", true},
+		{"nodata_soa", reply("wweir.cc.", dns.TypeA, dns.RcodeSuccess, nil, []dns.RR{soa}), "wweir.cc.", true},
+		{"nxdomain_no_soa", reply("wweir.cc.", dns.TypeA, dns.RcodeNameError, nil, nil), "wweir.cc.", false},
+		{"servfail", reply("wweir.cc.", dns.TypeA, dns.RcodeServerFailure, nil, nil), "wweir.cc.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(16, nil)
+			c.Set(tt.msg, false)
+
+			q := new(dns.Msg).SetQuestion(tt.query, dns.TypeA)
+			got := c.Get(q, false)
+			if (got != nil) != tt.want {
+				t.Fatalf("Cache.Get() = %v, want hit %v", got, tt.want)
+			}
+			if got != nil && (got.Id != q.Id || got.Rcode != tt.msg.Rcode) {
+				t.Errorf("Cache.Get() = %v, want id %d rcode %d", got, q.Id, tt.msg.Rcode)
+			}
+		})
+	}
+}
+
+func TestCache_LRU(t *testing.T) {
+	c := NewCache(2, nil)
+	for _, name := range []string{"a.cc.", "b.cc.", "c.cc."} {
+		c.Set(reply(name, dns.TypeA, dns.RcodeSuccess, []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(1, 2, 3, 4),
+		}}, nil), false)
+		if name == "b.cc." {
+			c.Get(new(dns.Msg).SetQuestion("a.cc.", dns.TypeA), false) // a.cc. become the latest used
+		}
+	}
+
+	for name, want := range map[string]bool{"a.cc.": true, "b.cc.": false, "c.cc.": true} {
+		if got := c.Get(new(dns.Msg).SetQuestion(name, dns.TypeA), false) != nil; got != want {
+			t.Errorf("Cache.Get(%s) = %v, want %v", name, got, want)
+		}
+	}
+	if c.Len() != 2 || c.Hits() != 3 || c.Misses() != 1 {
+		t.Errorf("len %d, hits %d, misses %d", c.Len(), c.Hits(), c.Misses())
+	}
+}
+
+func TestCache_Purge(t *testing.T) {
+	c := NewCache(16, nil)
+	for _, name := range []string{"a.wweir.cc.", "b.wweir.cc.", "a.cc."} {
+		c.Set(reply(name, dns.TypeA, dns.RcodeSuccess, []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(1, 2, 3, 4),
+		}}, nil), false)
+	}
+
+	c.Purge(func(name string) bool { return strings.HasSuffix(name, ".wweir.cc") })
+	for name, want := range map[string]bool{"a.wweir.cc.": false, "b.wweir.cc.": false, "a.cc.": true} {
+		if got := c.Get(new(dns.Msg).SetQuestion(name, dns.TypeA), false) != nil; got != want {
+			t.Errorf("Cache.Get(%s) = %v, want %v", name, got, want)
+		}
+	}
+	if c.Len() != 1 {
+		t.Errorf("len %d, want 1", c.Len())
+	}
+}
+
+func TestCache_Remote(t *testing.T) {
+	prefetched := make(chan bool, 1)
+	c := NewCache(16, func(r *dns.Msg, remote bool) { prefetched <- remote })
+	c.Set(reply("wweir.cc.", dns.TypeA, dns.RcodeSuccess, []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "wweir.cc.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 1},
+		A:   net.IPv4(1, 2, 3, 4),
+	}}, nil), true)
+
+	q := new(dns.Msg).SetQuestion("wweir.cc.", dns.TypeA)
+	if got := c.Get(q, false); got != nil {
+		t.Fatalf("Cache.Get(local) = %v, want the remote answer kept apart", got)
+	}
+	for i := 0; i < prefetchHits; i++ {
+		c.Get(q, true)
+	}
+	if c.Hits() != prefetchHits || c.Misses() != 1 {
+		t.Fatalf("hits %d, misses %d", c.Hits(), c.Misses())
+	}
+
+	// the entry of 1s ttl is in the last tenth of its lifetime after 0.9s
+	time.Sleep(950 * time.Millisecond)
+	if c.Get(q, true) == nil {
+		t.Fatal("Cache.Get(remote) = nil before expiry")
+	}
+	select {
+	case remote := <-prefetched:
+		if !remote {
+			t.Error("prefetch remote = false, want the way the entry was resolved")
+		}
+	case <-time.After(time.Second):
+		t.Error("prefetch not triggered")
+	}
+}
@@ -0,0 +1,205 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FakeIP allocate addresses in a reserved range for domains, and map them back.
+// The oldest mapping is recycled once the range is exhausted.
+type FakeIP struct {
+	base uint32
+	size uint32
+
+	sync.RWMutex
+	next      uint32 // offset to allocate next
+	ip2domain map[uint32]string
+	domain2ip map[string]uint32
+	dirty     bool
+}
+
+// NewFakeIP create a pool from an IPv4 cidr, eg: 198.18.0.0/15
+func NewFakeIP(cidr string) (*FakeIP, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits != 32 || bits-ones < 2 {
+		return nil, fmt.Errorf("invalid fake ip range: %s, IPv4 with at least 2 hosts required", cidr)
+	}
+
+	return &FakeIP{
+		base:      binary.BigEndian.Uint32(ipNet.IP.To4()),
+		size:      1 << uint(bits-ones),
+		next:      1, // skip network address
+		ip2domain: map[uint32]string{},
+		domain2ip: map[string]uint32{},
+	}, nil
+}
+
+func trimDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+func (f *FakeIP) toIP(offset uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, f.base+offset)
+	return ip
+}
+
+func (f *FakeIP) offset(ip net.IP) (uint32, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return 0, false
+	}
+
+	offset := binary.BigEndian.Uint32(ip4) - f.base
+	return offset, offset < f.size
+}
+
+// Contains check if the ip is in the fake ip range
+func (f *FakeIP) Contains(ip net.IP) bool {
+	_, ok := f.offset(ip)
+	return ok
+}
+
+// IP return the fake ip of domain, allocate one if not exist
+func (f *FakeIP) IP(domain string) net.IP {
+	domain = trimDomain(domain)
+
+	f.RLock()
+	offset, ok := f.domain2ip[domain]
+	f.RUnlock()
+	if ok {
+		return f.toIP(offset)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if offset, ok := f.domain2ip[domain]; ok {
+		return f.toIP(offset)
+	}
+
+	offset = f.next
+	if f.next++; f.next >= f.size-1 { // skip broadcast address
+		f.next = 1
+	}
+	if old, ok := f.ip2domain[offset]; ok {
+		delete(f.domain2ip, old)
+	}
+	f.ip2domain[offset] = domain
+	f.domain2ip[domain] = offset
+	f.dirty = true
+	return f.toIP(offset)
+}
+
+// Domain return the domain the fake ip allocated for
+func (f *FakeIP) Domain(ip net.IP) (string, bool) {
+	offset, ok := f.offset(ip)
+	if !ok {
+		return "", false
+	}
+
+	f.RLock()
+	defer f.RUnlock()
+	domain, ok := f.ip2domain[offset]
+	return domain, ok
+}
+
+// WriteTo write the mappings as lines of `ip domain`, the oldest first
+func (f *FakeIP) WriteTo(w io.Writer) (n int64, err error) {
+	f.Lock()
+	defer f.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for i := uint32(0); i < f.size; i++ {
+		offset := (f.next + i) % f.size
+		if domain, ok := f.ip2domain[offset]; ok {
+			cnt, err := fmt.Fprintln(bw, f.toIP(offset), domain)
+			if n += int64(cnt); err != nil {
+				return n, err
+			}
+		}
+	}
+	f.dirty = false
+	return n, bw.Flush()
+}
+
+// ReadFrom load the mappings written by WriteTo
+func (f *FakeIP) ReadFrom(r io.Reader) (n int64, err error) {
+	f.Lock()
+	defer f.Unlock()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		n += int64(len(scanner.Bytes())) + 1
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		offset, ok := f.offset(net.ParseIP(fields[0]))
+		if !ok || offset == 0 || offset >= f.size-1 {
+			continue // fake ip range changed
+		}
+		if old, ok := f.ip2domain[offset]; ok {
+			delete(f.domain2ip, old)
+		}
+		domain := trimDomain(fields[1])
+		if old, ok := f.domain2ip[domain]; ok {
+			delete(f.ip2domain, old)
+		}
+		f.ip2domain[offset] = domain
+		f.domain2ip[domain] = offset
+
+		if f.next = offset + 1; f.next >= f.size-1 {
+			f.next = 1
+		}
+	}
+	return n, scanner.Err()
+}
+
+// Load read the mappings from file, a missing file is not an error
+func (f *FakeIP) Load(file string) error {
+	fd, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer fd.Close()
+
+	_, err = f.ReadFrom(fd)
+	return err
+}
+
+// Save write the mappings into file safely if changed
+func (f *FakeIP) Save(file string) error {
+	f.RLock()
+	dirty := f.dirty
+	f.RUnlock()
+	if !dirty {
+		return nil
+	}
+
+	fd, err := os.OpenFile(file+"~", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteTo(fd); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file+"~", file)
+}
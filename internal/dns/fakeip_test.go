@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestFakeIP(t *testing.T) {
+	f, err := NewFakeIP("198.18.0.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := f.IP("a.wweir.cc."), f.IP("B.wweir.cc")
+	if a.String() != "198.18.0.1" || b.String() != "198.18.0.2" {
+		t.Fatalf("FakeIP.IP() = %s, %s", a, b)
+	}
+	if got := f.IP("a.wweir.cc"); !got.Equal(a) {
+		t.Errorf("FakeIP.IP() = %s, want %s", got, a)
+	}
+	if domain, ok := f.Domain(b); !ok || domain != "b.wweir.cc" {
+		t.Errorf("FakeIP.Domain(%s) = %s, %v", b, domain, ok)
+	}
+	if f.Contains(net.ParseIP("198.18.0.4")) || !f.Contains(net.ParseIP("198.18.0.3")) {
+		t.Error("FakeIP.Contains() mismatch range")
+	}
+
+	// range exhausted, the oldest one is recycled
+	if c := f.IP("c.wweir.cc"); !c.Equal(a) {
+		t.Errorf("FakeIP.IP() = %s, want %s", c, a)
+	}
+	if _, ok := f.Domain(net.ParseIP("198.18.0.3")); ok {
+		t.Error("broadcast address allocated")
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := f.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "198.18.0.2 b.wweir.cc\n198.18.0.1 c.wweir.cc\n" {
+		t.Errorf("FakeIP.WriteTo() = %q", buf.String())
+	}
+
+	loaded, _ := NewFakeIP("198.18.0.0/30")
+	if _, err := loaded.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if domain, _ := loaded.Domain(a); domain != "c.wweir.cc" {
+		t.Errorf("FakeIP.Domain(%s) = %s after reload", a, domain)
+	}
+	if d := loaded.IP("d.wweir.cc"); !d.Equal(b) {
+		t.Errorf("FakeIP.IP() = %s after reload, want oldest %s recycled", d, b)
+	}
+}
+
+func TestNewFakeIP(t *testing.T) {
+	for _, cidr := range []string{"198.18.0.0/31", "fc00::/64", "198.18.0.0"} {
+		if _, err := NewFakeIP(cidr); err == nil {
+			t.Errorf("NewFakeIP(%s) should fail", cidr)
+		}
+	}
+}
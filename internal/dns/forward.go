@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wweir/sower/util"
+)
+
+// Forward pick upstream by domain for split dns, the first matched rule wins
+type Forward []forwardRule
+
+type forwardRule struct {
+	*util.Node
+	*Upstream
+}
+
+// Add forward the domains to upstream servers. Domains are in util.Node syntax,
+// CIDRs are translated into reverse zones, eg: 10.0.0.0/8 => **.10.in-addr.arpa
+func (f *Forward) Add(domains []string, strategy string, timeout time.Duration, addrs ...string) error {
+	upstream, err := NewUpstream(strategy, timeout, addrs...)
+	if err != nil {
+		return err
+	}
+
+	node := util.NewNodeFromRules()
+	for _, domain := range domains {
+		if !strings.Contains(domain, "/") {
+			node.Add(domain)
+			continue
+		}
+
+		zones, err := ReverseZones(domain)
+		if err != nil {
+			return err
+		}
+		for _, zone := range zones {
+			node.Add("**." + zone)
+		}
+	}
+
+	*f = append(*f, forwardRule{node, upstream})
+	return nil
+}
+
+// Match return the upstream for domain, nil if not matched
+func (f Forward) Match(domain string) *Upstream {
+	for _, rule := range f {
+		if rule.Match(domain) {
+			return rule.Upstream
+		}
+	}
+	return nil
+}
+
+// ReverseZones translate cidr into reverse dns zones, expanded to octet / nibble boundary
+func ReverseZones(cidr string) ([]string, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, _ := ipNet.Mask.Size()
+
+	// bits of each label, and the digits of label
+	step, suffix, format := 8, "in-addr.arpa", func(b byte) string { return strconv.Itoa(int(b)) }
+	ip := []byte(ipNet.IP.To4())
+	if ip == nil {
+		ip = []byte(ipNet.IP.To16())
+		step, suffix, format = 4, "ip6.arpa", func(b byte) string { return strconv.FormatInt(int64(b), 16) }
+	}
+
+	labels := func(ip []byte, count int) []string {
+		out := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			if step == 8 {
+				out = append(out, format(ip[i]))
+			} else if i%2 == 0 {
+				out = append(out, format(ip[i/2]>>4))
+			} else {
+				out = append(out, format(ip[i/2]&0x0f))
+			}
+		}
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+		return out
+	}
+
+	count := (ones + step - 1) / step // labels of the expanded zones
+	expand := count*step - ones
+
+	zones := make([]string, 0, 1<<uint(expand))
+	for i := 0; i < 1<<uint(expand); i++ {
+		sub := append([]byte{}, ip...)
+		bit := ones
+		for j := expand - 1; j >= 0; j, bit = j-1, bit+1 {
+			if i&(1<<uint(j)) != 0 {
+				sub[bit/8] |= 0x80 >> uint(bit%8)
+			}
+		}
+
+		zone := append(labels(sub, count), suffix)
+		zones = append(zones, strings.Join(zone, "."))
+	}
+	return zones, nil
+}
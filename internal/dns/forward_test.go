@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReverseZones(t *testing.T) {
+	tests := []struct {
+		cidr string
+		want []string
+	}{
+		{"10.0.0.0/8", []string{"10.in-addr.arpa"}},
+		{"192.168.1.0/24", []string{"1.168.192.in-addr.arpa"}},
+		{"172.16.0.0/15", []string{"16.172.in-addr.arpa", "17.172.in-addr.arpa"}},
+		{"192.168.1.1/32", []string{"1.1.168.192.in-addr.arpa"}},
+		{"fd00::/8", []string{"d.f.ip6.arpa"}},
+		{"2001:db8::/31", []string{"8.b.d.0.1.0.0.2.ip6.arpa", "9.b.d.0.1.0.0.2.ip6.arpa"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			got, err := ReverseZones(tt.cidr)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReverseZones() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestForward_Match(t *testing.T) {
+	f := Forward{}
+	if err := f.Add([]string{"**.corp.example", "10.0.0.0/8"}, "", time.Second, "10.0.0.53"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add([]string{"**.local"}, "", time.Second, "192.168.1.1:5353"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"git.corp.example.", "10.0.0.53:53"},
+		{"corp.example", "10.0.0.53:53"},
+		{"4.3.2.10.in-addr.arpa.", "10.0.0.53:53"},
+		{"4.3.2.11.in-addr.arpa.", ""},
+		{"printer.local.", "192.168.1.1:5353"},
+		{"wweir.cc.", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if upstream := f.Match(tt.domain); upstream != nil {
+			got = upstream.Addrs()[0]
+		}
+		if got != tt.want {
+			t.Errorf("Forward.Match(%s) = %s, want %s", tt.domain, got, tt.want)
+		}
+	}
+}
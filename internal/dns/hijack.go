@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// Service binding record types, not supported by the dns library yet
+// https://tools.ietf.org/html/draft-ietf-dnsop-svcb-https
+const (
+	TypeSVCB  uint16 = 64
+	TypeHTTPS uint16 = 65
+)
+
+// Hijack answer the request of a proxied domain with the serve ips.
+// A family without serve ip gets an empty NOERROR answer.
+// Return nil for the record types should be relayed to upstream, eg: MX / TXT / SRV.
+func Hijack(r *dns.Msg, ipv4, ipv6 net.IP, ttl uint32) *dns.Msg {
+	q := r.Question[0]
+	if q.Qclass != dns.ClassINET {
+		return nil
+	}
+
+	a := func() []dns.RR {
+		if ipv4 == nil {
+			return nil
+		}
+		return []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   ipv4.To4(),
+		}}
+	}
+	aaaa := func() []dns.RR {
+		if ipv6 == nil {
+			return nil
+		}
+		return []dns.RR{&dns.AAAA{
+			Hdr:  dns.RR_Header{Name: q.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+			AAAA: ipv6.To16(),
+		}}
+	}
+
+	m := new(dns.Msg).SetReply(r)
+	switch q.Qtype {
+	case dns.TypeA:
+		m.Answer = a()
+	case dns.TypeAAAA:
+		m.Answer = aaaa()
+	case dns.TypeANY:
+		m.Answer = append(a(), aaaa()...)
+	case TypeSVCB, TypeHTTPS:
+		// the ip hints and ECH config in upstream answer bypass the serve ips
+	default:
+		return nil
+	}
+	return m
+}
+
+// Block answer the request of a blocked domain with NXDOMAIN,
+// or unspecified addresses if zero is set
+func Block(r *dns.Msg, zero bool) *dns.Msg {
+	if !zero {
+		return new(dns.Msg).SetRcode(r, dns.RcodeNameError)
+	}
+
+	if msg := Hijack(r, net.IPv4zero, net.IPv6zero, 60); msg != nil {
+		return msg
+	}
+	return new(dns.Msg).SetReply(r)
+}
@@ -0,0 +1,58 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestHijack(t *testing.T) {
+	ipv4, ipv6 := net.ParseIP("127.0.0.1"), net.ParseIP("::1")
+
+	tests := []struct {
+		name       string
+		qtype      uint16
+		ipv4, ipv6 net.IP
+		relay      bool
+		want       []string
+	}{
+		{"a", dns.TypeA, ipv4, ipv6, false, []string{"127.0.0.1"}},
+		{"aaaa", dns.TypeAAAA, ipv4, ipv6, false, []string{"::1"}},
+		{"any", dns.TypeANY, ipv4, ipv6, false, []string{"127.0.0.1", "::1"}},
+		{"a_without_ipv4", dns.TypeA, nil, ipv6, false, nil},
+		{"aaaa_without_ipv6", dns.TypeAAAA, ipv4, nil, false, nil},
+		{"https", TypeHTTPS, ipv4, ipv6, false, nil},
+		{"svcb", TypeSVCB, ipv4, ipv6, false, nil},
+		{"mx", dns.TypeMX, ipv4, ipv6, true, nil},
+		{"txt", dns.TypeTXT, ipv4, ipv6, true, nil},
+		{"srv", dns.TypeSRV, ipv4, ipv6, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(dns.Msg).SetQuestion("wweir.cc.", tt.qtype)
+			msg := Hijack(r, tt.ipv4, tt.ipv6, 20)
+			if tt.relay {
+				if msg != nil {
+					t.Errorf("Hijack() = %v, want relay to upstream", msg)
+				}
+				return
+			}
+
+			if msg == nil || msg.Rcode != dns.RcodeSuccess || msg.Id != r.Id {
+				t.Fatalf("Hijack() = %v, want NOERROR answer", msg)
+			}
+			if len(msg.Answer) != len(tt.want) {
+				t.Fatalf("Hijack() = %v, want %v", msg.Answer, tt.want)
+			}
+			for i, rr := range msg.Answer {
+				if got := AnswerIPs(&dns.Msg{Answer: []dns.RR{rr}})[0].String(); got != tt.want[i] {
+					t.Errorf("Hijack() answer %d = %s, want %s", i, got, tt.want[i])
+				}
+				if rr.Header().Name != "wweir.cc." {
+					t.Errorf("Hijack() answer name = %s", rr.Header().Name)
+				}
+			}
+		})
+	}
+}
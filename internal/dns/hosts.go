@@ -0,0 +1,223 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/wweir/sower/util"
+)
+
+const hostsTTL = 10
+
+// Hosts answer local records, configured or loaded from hosts files
+type Hosts struct {
+	static    map[string]*record
+	wildcards map[string]*record // rule => record, the most specific rule matched wins
+	rules     *util.Node
+
+	sync.RWMutex
+	files   map[string]*record
+	modTime map[string]time.Time
+}
+
+type record struct {
+	ips   []net.IP
+	cname string
+}
+
+// NewHosts create hosts from records like `name => [ip...]` or `name => [cname]`,
+// wildcard names are matched in util.Node syntax, eg: *.dev.lan / **.lan
+func NewHosts(records map[string][]string, files ...string) (*Hosts, error) {
+	h := &Hosts{
+		static:    map[string]*record{},
+		wildcards: map[string]*record{},
+		files:     map[string]*record{},
+		modTime:   map[string]time.Time{},
+	}
+	for name, vals := range records {
+		rec := &record{}
+		for _, val := range vals {
+			if ip := net.ParseIP(val); ip != nil {
+				rec.ips = append(rec.ips, ip)
+			} else if len(vals) == 1 {
+				rec.cname = dns.Fqdn(val)
+			} else {
+				return nil, fmt.Errorf("invalid host record: %s => %v", name, vals)
+			}
+		}
+
+		if name = trimDomain(name); strings.Contains(name, "*") {
+			h.wildcards[name] = rec
+		} else {
+			h.static[name] = rec
+		}
+	}
+
+	rules := make([]string, 0, len(h.wildcards))
+	for name := range h.wildcards {
+		rules = append(rules, name)
+	}
+	h.rules = util.NewNodeFromRules(rules...)
+
+	for _, file := range files {
+		h.modTime[file] = time.Time{}
+	}
+	return h, h.Reload()
+}
+
+// Reload read the hosts files changed since last load
+func (h *Hosts) Reload() error {
+	h.RLock()
+	changed := false
+	for file, modTime := range h.modTime {
+		if info, err := os.Stat(file); err == nil && !info.ModTime().Equal(modTime) {
+			changed = true
+		}
+	}
+	h.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	records, modTime := map[string]*record{}, map[string]time.Time{}
+	for file := range h.modTime {
+		info, err := os.Stat(file)
+		if os.IsNotExist(err) {
+			modTime[file] = time.Time{}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := parseHostsFile(file, records); err != nil {
+			return err
+		}
+		modTime[file] = info.ModTime()
+	}
+
+	h.Lock()
+	h.files, h.modTime = records, modTime
+	h.Unlock()
+	return nil
+}
+
+func parseHostsFile(file string, records map[string]*record) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+
+		for _, name := range fields[1:] {
+			name = trimDomain(name)
+			if rec, ok := records[name]; ok {
+				rec.ips = append(rec.ips, ip)
+			} else {
+				records[name] = &record{ips: []net.IP{ip}}
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func (h *Hosts) find(name string) *record {
+	name = trimDomain(name)
+	if rec, ok := h.static[name]; ok {
+		return rec
+	}
+
+	h.RLock()
+	rec, ok := h.files[name]
+	h.RUnlock()
+	if ok {
+		return rec
+	}
+
+	if rule, ok := h.rules.MatchRule(name); ok {
+		return h.wildcards[rule]
+	}
+	return nil
+}
+
+// Lookup answer the request if the name is in hosts, or return nil.
+// CNAME targets out of hosts are resolved by resolve.
+func (h *Hosts) Lookup(r *dns.Msg, resolve func(r *dns.Msg) (*dns.Msg, error)) *dns.Msg {
+	if h == nil || len(r.Question) == 0 {
+		return nil
+	}
+	q := r.Question[0]
+	if q.Qclass != dns.ClassINET {
+		return nil
+	}
+	switch q.Qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypeANY, dns.TypeCNAME:
+	default:
+		return nil
+	}
+
+	m := new(dns.Msg).SetReply(r)
+	name := q.Name
+	for depth := 0; depth < 8; depth++ { // break cname loop
+		rec := h.find(name)
+		switch {
+		case rec == nil && depth == 0:
+			return nil
+
+		case rec == nil:
+			if q.Qtype != dns.TypeCNAME && resolve != nil {
+				if msg, err := resolve(new(dns.Msg).SetQuestion(name, q.Qtype)); err == nil {
+					m.Answer = append(m.Answer, msg.Answer...)
+				}
+			}
+			return m
+
+		case rec.cname != "":
+			m.Answer = append(m.Answer, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: hostsTTL},
+				Target: rec.cname,
+			})
+			if q.Qtype == dns.TypeCNAME {
+				return m
+			}
+			name = rec.cname
+
+		default:
+			for _, ip := range rec.ips {
+				if ip4 := ip.To4(); ip4 != nil && (q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY) {
+					m.Answer = append(m.Answer, &dns.A{
+						Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: hostsTTL},
+						A:   ip4,
+					})
+				} else if ip4 == nil && (q.Qtype == dns.TypeAAAA || q.Qtype == dns.TypeANY) {
+					m.Answer = append(m.Answer, &dns.AAAA{
+						Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: hostsTTL},
+						AAAA: ip,
+					})
+				}
+			}
+			return m
+		}
+	}
+	return m
+}
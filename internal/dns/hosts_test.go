@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestHosts_Lookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(file, []byte("# comment\n10.0.0.1 file.lan alias.file.lan # trailing\nfd00::1 file.lan\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewHosts(map[string][]string{
+		"nas.lan":      {"192.168.1.10", "fd00::10"},
+		"*.dev.lan":    {"127.0.0.1"},
+		"**.test.lan":  {"127.0.0.2"},
+		"*.x.test.lan": {"127.0.0.3"},
+		"www.lan":      {"nas.lan"},
+		"ext.lan":      {"wweir.cc"},
+		"loop.lan":     {"loop.lan"},
+		"v6only.lan":   {"::1"},
+	}, file, filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolve := func(r *dns.Msg) (*dns.Msg, error) {
+		if r.Question[0].Name != "wweir.cc." {
+			return nil, errors.New("unexpected")
+		}
+		m := new(dns.Msg).SetReply(r)
+		m.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "wweir.cc.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IPv4(1, 1, 1, 1)}}
+		return m, nil
+	}
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  []string // nil for not answered
+	}{
+		{"nas.lan.", dns.TypeA, []string{"192.168.1.10"}},
+		{"NAS.lan.", dns.TypeAAAA, []string{"fd00::10"}},
+		{"nas.lan.", dns.TypeANY, []string{"192.168.1.10", "fd00::10"}},
+		{"nas.lan.", dns.TypeMX, nil},
+		{"a.dev.lan.", dns.TypeA, []string{"127.0.0.1"}},
+		{"a.b.dev.lan.", dns.TypeA, nil},
+		{"a.x.test.lan.", dns.TypeA, []string{"127.0.0.3"}},
+		{"a.b.x.test.lan.", dns.TypeA, []string{"127.0.0.2"}},
+		{"www.lan.", dns.TypeA, []string{"nas.lan.", "192.168.1.10"}},
+		{"www.lan.", dns.TypeCNAME, []string{"nas.lan."}},
+		{"ext.lan.", dns.TypeA, []string{"wweir.cc.", "1.1.1.1"}},
+		{"loop.lan.", dns.TypeA, []string{"loop.lan.", "loop.lan.", "loop.lan.", "loop.lan.", "loop.lan.", "loop.lan.", "loop.lan.", "loop.lan."}},
+		{"v6only.lan.", dns.TypeA, []string{}},
+		{"alias.file.lan.", dns.TypeA, []string{"10.0.0.1"}},
+		{"file.lan.", dns.TypeAAAA, []string{"fd00::1"}},
+		{"other.lan.", dns.TypeA, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := h.Lookup(new(dns.Msg).SetQuestion(tt.name, tt.qtype), resolve)
+			if (msg != nil) != (tt.want != nil) {
+				t.Fatalf("Hosts.Lookup() = %v, want %v", msg, tt.want)
+			}
+			if msg == nil {
+				return
+			}
+
+			var got []string
+			for _, rr := range msg.Answer {
+				switch rr := rr.(type) {
+				case *dns.A:
+					got = append(got, rr.A.String())
+				case *dns.AAAA:
+					got = append(got, rr.AAAA.String())
+				case *dns.CNAME:
+					got = append(got, rr.Target)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Hosts.Lookup() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Hosts.Lookup() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHosts_LookupNoQuestion(t *testing.T) {
+	h, err := NewHosts(map[string][]string{"nas.lan": {"192.168.1.10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := h.Lookup(new(dns.Msg), nil); msg != nil {
+		t.Errorf("Hosts.Lookup() = %v, want nil", msg)
+	}
+}
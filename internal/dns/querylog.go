@@ -0,0 +1,263 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Decisions of the dns relay
+const (
+	Proxy   = "proxy"
+	Direct  = "direct"
+	Blocked = "blocked"
+	Cached  = "cached"
+	Local   = "local"
+)
+
+const maxStatKeys = 4096
+
+// reopenInterval is the least interval to reopen the file after failures
+const reopenInterval = 10 * time.Second
+
+// Query is a record of the dns relay
+type Query struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Name     string    `json:"name"`
+	Qtype    string    `json:"qtype"`
+	Decision string    `json:"decision"`
+	Upstream string    `json:"upstream,omitempty"`
+	Rcode    string    `json:"rcode"`
+	Latency  float64   `json:"latency_ms"`
+}
+
+// NewQuery build the record of request r answered by msg, msg is nil on failure
+func NewQuery(client string, r, msg *dns.Msg, decision, upstream string, start time.Time) *Query {
+	q := &Query{
+		Time:     start,
+		Client:   client,
+		Name:     trimDomain(r.Question[0].Name),
+		Qtype:    dns.TypeToString[r.Question[0].Qtype],
+		Decision: decision,
+		Upstream: upstream,
+		Rcode:    dns.RcodeToString[dns.RcodeServerFailure],
+		Latency:  float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if q.Qtype == "" {
+		q.Qtype = strconv.Itoa(int(r.Question[0].Qtype))
+	}
+	if msg != nil {
+		q.Rcode = dns.RcodeToString[msg.Rcode]
+	}
+	return q
+}
+
+// Count is the hits of a domain or client
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// QueryLog keep the latest queries in a ring, with the hits of domains and clients.
+// Queries are also appended into a JSON-lines file if set, rotated by size.
+type QueryLog struct {
+	sync.Mutex
+	ring    []*Query
+	next    int
+	domains map[string]int
+	clients map[string]int
+
+	file     string
+	maxSize  int64
+	backups  int
+	fd       *os.File
+	fileSize int64
+	reopenAt time.Time // nil fd is reopened after, once rotation failed
+}
+
+// NewQueryLog create a query log keeping size queries in memory.
+// Rotate the file once it reaches maxSize bytes, keep at most backups old files.
+func NewQueryLog(size int, file string, maxSize int64, backups int) (*QueryLog, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid query log size: %d", size)
+	}
+
+	l := &QueryLog{
+		ring:    make([]*Query, size),
+		domains: map[string]int{},
+		clients: map[string]int{},
+		file:    file,
+		maxSize: maxSize,
+		backups: backups,
+	}
+	if file == "" {
+		return l, nil
+	}
+	return l, l.open()
+}
+
+func (l *QueryLog) open() error {
+	fd, err := os.OpenFile(l.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+
+	l.fd, l.fileSize = fd, info.Size()
+	return nil
+}
+
+func (l *QueryLog) rotate() error {
+	l.fd.Close()
+	l.fd = nil
+	for i := l.backups; i > 0; i-- {
+		src := l.file
+		if i > 1 {
+			src = l.file + "." + strconv.Itoa(i-1)
+		}
+		if err := os.Rename(src, l.file+"."+strconv.Itoa(i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if l.backups <= 0 {
+		if err := os.Remove(l.file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// Add record a query, the error is about writing the file
+func (l *QueryLog) Add(q *Query) error {
+	if l == nil {
+		return nil
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if len(l.ring) != 0 {
+		l.ring[l.next] = q
+		l.next = (l.next + 1) % len(l.ring)
+	}
+	count(l.domains, q.Name)
+	count(l.clients, q.Client)
+
+	if l.file == "" {
+		return nil
+	}
+	if l.fd == nil {
+		if time.Now().Before(l.reopenAt) {
+			return nil // dropped until reopen, the failure was reported already
+		}
+		if err := l.open(); err != nil {
+			l.reopenAt = time.Now().Add(reopenInterval)
+			return fmt.Errorf("reopen query log: %w", err)
+		}
+	}
+	line, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	if l.maxSize > 0 && l.fileSize+int64(len(line))+1 > l.maxSize && l.fileSize > 0 {
+		if err := l.rotate(); err != nil {
+			l.reopenAt = time.Now().Add(reopenInterval)
+			return fmt.Errorf("rotate query log: %w", err)
+		}
+	}
+	n, err := l.fd.Write(append(line, '\n'))
+	l.fileSize += int64(n)
+	return err
+}
+
+// count increase the hits of key, halve all hits and drop the rare ones once too many keys
+func count(stats map[string]int, key string) {
+	stats[key]++
+	if len(stats) <= maxStatKeys {
+		return
+	}
+
+	for k, v := range stats {
+		if v /= 2; v == 0 {
+			delete(stats, k)
+		} else {
+			stats[k] = v
+		}
+	}
+}
+
+// Recent return the latest n queries, the newest first
+func (l *QueryLog) Recent(n int) []*Query {
+	l.Lock()
+	defer l.Unlock()
+
+	out := make([]*Query, 0, n)
+	for i := 1; i <= len(l.ring) && len(out) < n; i++ {
+		q := l.ring[(l.next-i+len(l.ring))%len(l.ring)]
+		if q == nil {
+			break
+		}
+		out = append(out, q)
+	}
+	return out
+}
+
+// TopDomains return the n most queried domains
+func (l *QueryLog) TopDomains(n int) []Count {
+	l.Lock()
+	defer l.Unlock()
+	return top(l.domains, n)
+}
+
+// TopClients return the n clients query most
+func (l *QueryLog) TopClients(n int) []Count {
+	l.Lock()
+	defer l.Unlock()
+	return top(l.clients, n)
+}
+
+func top(stats map[string]int, n int) []Count {
+	counts := make([]Count, 0, len(stats))
+	for k, v := range stats {
+		counts = append(counts, Count{k, v})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Key < counts[j].Key
+	})
+
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// Close close the log file
+func (l *QueryLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.file = "" // never reopen
+	if l.fd == nil {
+		return nil
+	}
+	fd := l.fd
+	l.fd = nil
+	return fd.Close()
+}
@@ -0,0 +1,121 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "querylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "query.log")
+
+	l, err := NewQueryLog(3, file, 400, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for _, q := range []struct{ client, name string }{
+		{"10.0.0.1", "a.com."}, {"10.0.0.2", "b.com."}, {"10.0.0.1", "a.com."}, {"10.0.0.1", "c.com."},
+	} {
+		r := new(dns.Msg).SetQuestion(q.name, dns.TypeA)
+		msg := new(dns.Msg).SetRcode(r, dns.RcodeNameError)
+		if err := l.Add(NewQuery(q.client, r, msg, Direct, "1.1.1.1:53", time.Now())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := []string{}
+	for _, q := range l.Recent(10) {
+		names = append(names, q.Name)
+	}
+	if want := []string{"c.com", "a.com", "b.com"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Recent() = %v, want %v", names, want)
+	}
+	if got, want := l.TopDomains(1), []Count{{"a.com", 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TopDomains() = %v, want %v", got, want)
+	}
+	if got, want := l.TopClients(2), []Count{{"10.0.0.1", 3}, {"10.0.0.2", 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("TopClients() = %v, want %v", got, want)
+	}
+
+	lines := 0
+	for _, f := range []string{file, file + ".1"} {
+		fd, err := os.Open(f)
+		if err != nil {
+			t.Fatalf("rotated file: %s", err)
+		}
+		scanner := bufio.NewScanner(fd)
+		for ; scanner.Scan(); lines++ {
+			q := Query{}
+			if err := json.Unmarshal(scanner.Bytes(), &q); err != nil || q.Rcode != "NXDOMAIN" || q.Qtype != "A" {
+				t.Errorf("invalid line: %s, %v", scanner.Text(), err)
+			}
+		}
+		fd.Close()
+	}
+	if lines != 4 {
+		t.Errorf("got %d lines in files, want 4", lines)
+	}
+}
+
+func TestQueryLog_InvalidSize(t *testing.T) {
+	if _, err := NewQueryLog(-1, "", 0, 0); err == nil {
+		t.Error("NewQueryLog(-1) succeeded, want error")
+	}
+}
+
+func TestQueryLog_RotateFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "querylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "query.log")
+
+	l, err := NewQueryLog(0, file, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	add := func() error {
+		r := new(dns.Msg).SetQuestion("a.com.", dns.TypeA)
+		return l.Add(NewQuery("10.0.0.1", r, nil, Direct, "", time.Now()))
+	}
+	if err := add(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the backup path is taken by a non-empty dir, rename fails
+	if err := os.MkdirAll(filepath.Join(file+".1", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := add(); err == nil {
+		t.Fatal("Add() rotate into a dir without error")
+	}
+	if err := add(); err != nil {
+		t.Errorf("Add() before reopen = %v, want dropped silently", err)
+	}
+
+	os.RemoveAll(file + ".1")
+	l.reopenAt = time.Time{}
+	if err := add(); err != nil {
+		t.Errorf("Add() after reopen = %v", err)
+	}
+	if _, err := os.Stat(file + ".1"); err != nil {
+		t.Errorf("rotated file: %s", err)
+	}
+}
@@ -0,0 +1,148 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/wweir/sower/util"
+)
+
+// Tunnel exchange dns messages over stream connections, eg: the sower tunnel
+type Tunnel struct {
+	dial    func() (net.Conn, error)
+	timeout time.Duration
+	idle    chan *dns.Conn
+}
+
+// NewTunnel create a tunnel resolver, connections are reused when possible
+func NewTunnel(timeout time.Duration, dial func() (net.Conn, error)) *Tunnel {
+	return &Tunnel{
+		dial:    dial,
+		timeout: timeout,
+		idle:    make(chan *dns.Conn, 4),
+	}
+}
+
+// Exchange send the request though the tunnel and wait for the answer
+func (t *Tunnel) Exchange(r *dns.Msg) (msg *dns.Msg, err error) {
+	for {
+		var conn *dns.Conn
+		reused := true
+		select {
+		case conn = <-t.idle:
+		default:
+			c, err := t.dial()
+			if err != nil {
+				return nil, err
+			}
+			conn, reused = &dns.Conn{Conn: c}, false
+		}
+
+		if msg, err = t.exchange(conn, r); err == nil {
+			select {
+			case t.idle <- conn:
+			default:
+				conn.Close()
+			}
+			return msg, nil
+		}
+		conn.Close()
+
+		// the idle connection may be closed by remote, retry with a new one
+		if !reused {
+			return nil, err
+		}
+	}
+}
+
+func (t *Tunnel) exchange(conn *dns.Conn, r *dns.Msg) (*dns.Msg, error) {
+	conn.SetDeadline(time.Now().Add(t.timeout))
+	if err := conn.WriteMsg(r); err != nil {
+		return nil, err
+	}
+
+	msg, err := conn.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if msg.Id != r.Id {
+		return nil, errors.New("dns message id mismatch")
+	}
+	return msg, nil
+}
+
+// ServeTunnel answer the requests read from conn by relaying them to upstream
+func ServeTunnel(conn net.Conn, upstream string, idleTimeout time.Duration) error {
+	c := &dns.Conn{Conn: conn}
+	udp, tcp := &dns.Client{}, &dns.Client{Net: "tcp"}
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		r, err := c.ReadMsg()
+		if err != nil {
+			return err
+		}
+
+		msg, _, err := udp.Exchange(r, upstream)
+		if err == nil && msg.Truncated {
+			msg, _, err = tcp.Exchange(r, upstream)
+		}
+		if err != nil {
+			msg = new(dns.Msg).SetRcode(r, dns.RcodeServerFailure)
+		}
+
+		if err := c.WriteMsg(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// bogus is the space poisoned answers point to: the reserved ranges, and the addresses
+// injected by the known poisoners. CDN and geo dns answers never fall into it.
+var bogus, _ = util.NewIPNodeFromCIDRs(true,
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/3",
+	"::/127", "fc00::/7", "fe80::/10", "ff00::/8",
+	"8.7.198.45", "37.61.54.158", "46.82.174.68", "59.24.3.173", "64.33.88.161",
+	"78.16.49.15", "93.46.8.89", "159.106.121.75", "203.98.7.65", "243.185.187.39",
+)
+
+// Poisoned check if the local answer looks poisoned compared with the remote one:
+// they share no address, and the local one points to bogus space while the remote one not.
+// Different addresses alone are normal for CDN and geo dns.
+func Poisoned(local, remote *dns.Msg) bool {
+	ipsLocal, ipsRemote := AnswerIPs(local), AnswerIPs(remote)
+	if len(ipsLocal) == 0 || len(ipsRemote) == 0 {
+		return false
+	}
+
+	bogusLocal := false
+	for _, ip := range ipsLocal {
+		for _, ipRemote := range ipsRemote {
+			if ip.Equal(ipRemote) {
+				return false
+			}
+		}
+		bogusLocal = bogusLocal || bogus.Match(ip)
+	}
+	for _, ip := range ipsRemote {
+		if bogus.Match(ip) {
+			return false // private zones, or both poisoned
+		}
+	}
+	return bogusLocal
+}
+
+// AnswerIPs return the A / AAAA addresses in answer section
+func AnswerIPs(msg *dns.Msg) (ips []net.IP) {
+	for _, rr := range msg.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			ips = append(ips, rr.A)
+		case *dns.AAAA:
+			ips = append(ips, rr.AAAA)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestTunnel_Exchange(t *testing.T) {
+	addr, srv := serve(t, dns.RcodeNameError)
+	defer srv.Shutdown()
+
+	dials := 0
+	tunnel := NewTunnel(time.Second, func() (net.Conn, error) {
+		dials++
+		c1, c2 := net.Pipe()
+		go ServeTunnel(c2, addr, time.Second)
+		return c1, nil
+	})
+
+	for i := 0; i < 3; i++ {
+		r := new(dns.Msg).SetQuestion("wweir.cc.", dns.TypeA)
+		msg, err := tunnel.Exchange(r)
+		if err != nil || msg.Id != r.Id || msg.Rcode != dns.RcodeNameError {
+			t.Fatalf("Tunnel.Exchange() = %v, %v", msg, err)
+		}
+	}
+	if dials != 1 {
+		t.Errorf("dial %d times, want connection reused", dials)
+	}
+}
+
+func TestPoisoned(t *testing.T) {
+	msg := func(ips ...string) *dns.Msg {
+		m := new(dns.Msg)
+		for _, ip := range ips {
+			m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Rrtype: dns.TypeA}, A: net.ParseIP(ip)})
+		}
+		return m
+	}
+
+	tests := []struct {
+		name string
+		a, b *dns.Msg
+		want bool
+	}{
+		{"empty", msg(), msg(), false},
+		{"overlap", msg("1.1.1.1", "2.2.2.2"), msg("2.2.2.2"), false},
+		{"cdn", msg("1.1.1.1"), msg("2.2.2.2"), false},
+		{"one_empty", msg("127.0.0.1"), msg(), false},
+		{"reserved", msg("127.0.0.1"), msg("2.2.2.2"), true},
+		{"known_poison", msg("93.46.8.89"), msg("2.2.2.2"), true},
+		{"private_zone", msg("10.0.0.1"), msg("10.0.0.2"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Poisoned(tt.a, tt.b); got != tt.want {
+				t.Errorf("Poisoned() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Upstream strategies
+const (
+	Failover = "failover" // try upstreams one by one in configured order
+	Race     = "race"     // query all upstreams in parallel, first answer wins
+	Latency  = "latency"  // try upstreams one by one, the fastest measured first
+)
+
+var errBadRcode = errors.New("upstream refused or failed")
+
+// maxBackoff limit the time an unreachable server is skipped for, it doubles on each failure
+const maxBackoff = time.Minute
+
+// Upstream relay dns requests to a group of upstream servers
+type Upstream struct {
+	strategy string
+	client   *dns.Client
+
+	sync.RWMutex
+	servers []*server
+}
+
+type server struct {
+	rtt   int64 // smoothed round-trip time in nanoseconds
+	down  int64 // unix nano until which the unreachable server is tried last
+	fails int32 // consecutive unreachable times
+	addr  string
+}
+
+// NewUpstream create an upstream group, port 53 is added to addrs without port
+func NewUpstream(strategy string, timeout time.Duration, addrs ...string) (*Upstream, error) {
+	switch strategy {
+	case "":
+		strategy = Failover
+	case Failover, Race, Latency:
+	default:
+		return nil, fmt.Errorf("invalid upstream strategy: %s", strategy)
+	}
+
+	u := &Upstream{strategy: strategy, client: &dns.Client{Timeout: timeout}}
+	return u, u.Reset(addrs...)
+}
+
+// ParseAddrs split comma separated dns server addresses
+func ParseAddrs(addrs string) []string {
+	var out []string
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
+
+// Reset replace the upstream servers
+func (u *Upstream) Reset(addrs ...string) error {
+	if len(addrs) == 0 {
+		return errors.New("no upstream dns server")
+	}
+
+	servers := make([]*server, 0, len(addrs))
+	for _, addr := range addrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		servers = append(servers, &server{addr: addr})
+	}
+
+	u.Lock()
+	u.servers = servers
+	u.Unlock()
+	return nil
+}
+
+// Addrs return the upstream server addresses in configured order
+func (u *Upstream) Addrs() []string {
+	u.RLock()
+	defer u.RUnlock()
+
+	addrs := make([]string, 0, len(u.servers))
+	for _, s := range u.servers {
+		addrs = append(addrs, s.addr)
+	}
+	return addrs
+}
+
+// Exchange relay the request with the strategy, return the answer and the server answered
+func (u *Upstream) Exchange(r *dns.Msg) (*dns.Msg, string, error) {
+	u.RLock()
+	servers := append([]*server{}, u.servers...)
+	u.RUnlock()
+
+	switch u.strategy {
+	case Race:
+		return u.race(r, servers)
+	case Latency:
+		// sort on a snapshot, the rtts change while sorting
+		rtts := make(map[*server]int64, len(servers))
+		for _, s := range servers {
+			rtts[s] = atomic.LoadInt64(&s.rtt)
+		}
+		sort.SliceStable(servers, func(i, j int) bool {
+			return rtts[servers[i]] < rtts[servers[j]]
+		})
+	}
+	return u.failover(r, servers)
+}
+
+// failover try the servers in order, the ones marked down are tried last
+func (u *Upstream) failover(r *dns.Msg, servers []*server) (msg *dns.Msg, addr string, err error) {
+	now := time.Now().UnixNano()
+	ordered := make([]*server, 0, len(servers))
+	var down []*server
+	for _, s := range servers {
+		if atomic.LoadInt64(&s.down) > now {
+			down = append(down, s)
+		} else {
+			ordered = append(ordered, s)
+		}
+	}
+
+	for _, s := range append(ordered, down...) {
+		if msg, err = u.exchange(r, s); err == nil {
+			return msg, s.addr, nil
+		}
+	}
+	return nil, "", err
+}
+
+func (u *Upstream) race(r *dns.Msg, servers []*server) (*dns.Msg, string, error) {
+	type result struct {
+		msg  *dns.Msg
+		addr string
+		err  error
+	}
+
+	ch := make(chan result, len(servers))
+	for _, s := range servers {
+		go func(s *server) {
+			msg, err := u.exchange(r.Copy(), s)
+			ch <- result{msg, s.addr, err}
+		}(s)
+	}
+
+	var err error
+	for range servers {
+		res := <-ch
+		if res.err == nil {
+			return res.msg, res.addr, nil
+		}
+		err = res.err
+	}
+	return nil, "", err
+}
+
+func (u *Upstream) exchange(r *dns.Msg, s *server) (*dns.Msg, error) {
+	msg, rtt, err := u.client.Exchange(r, s.addr)
+	if err == nil && msg != nil &&
+		(msg.Rcode == dns.RcodeServerFailure || msg.Rcode == dns.RcodeRefused) {
+		err = errBadRcode
+	}
+	if err != nil || msg == nil {
+		rtt = u.client.Timeout // penalize failed server
+		if rtt == 0 {
+			rtt = 2 * time.Second
+		}
+	}
+	if err != nil && err != errBadRcode || msg == nil {
+		s.markDown()
+	} else {
+		atomic.StoreInt32(&s.fails, 0)
+		atomic.StoreInt64(&s.down, 0)
+	}
+
+	// exponentially weighted moving average
+	if old := atomic.LoadInt64(&s.rtt); old == 0 {
+		atomic.StoreInt64(&s.rtt, int64(rtt))
+	} else {
+		atomic.StoreInt64(&s.rtt, (old*7+int64(rtt))/8)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("exchange with %s: %w", s.addr, err)
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("exchange with %s: empty answer", s.addr)
+	}
+	return msg, nil
+}
+
+// markDown skip the unreachable server for a backoff, 1s at first and doubled on each failure
+func (s *server) markDown() {
+	backoff := maxBackoff
+	if fails := atomic.AddInt32(&s.fails, 1); fails <= 6 {
+		backoff = time.Second << uint(fails-1)
+	}
+	atomic.StoreInt64(&s.down, time.Now().Add(backoff).UnixNano())
+}
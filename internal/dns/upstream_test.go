@@ -0,0 +1,109 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// serve start a local dns server answering every request with rcode
+func serve(t *testing.T, rcode int) (string, *dns.Server) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		w.WriteMsg(new(dns.Msg).SetRcode(r, rcode))
+	})}
+	go srv.ActivateAndServe()
+	return conn.LocalAddr().String(), srv
+}
+
+func TestUpstream_Exchange(t *testing.T) {
+	ok, srv := serve(t, dns.RcodeNameError)
+	defer srv.Shutdown()
+	fail, srv := serve(t, dns.RcodeServerFailure)
+	defer srv.Shutdown()
+	dead := func() string {
+		conn, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		defer conn.Close()
+		return conn.LocalAddr().String()
+	}()
+
+	tests := []struct {
+		strategy string
+		addrs    []string
+		want     string
+	}{
+		{Failover, []string{ok, fail}, ok},
+		{Failover, []string{dead, fail, ok}, ok},
+		{Failover, []string{dead, fail}, ""},
+		{Race, []string{dead, fail, ok}, ok},
+		{Race, []string{fail, dead}, ""},
+		{Latency, []string{fail, ok}, ok},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			u, err := NewUpstream(tt.strategy, 500*time.Millisecond, tt.addrs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			msg, addr, err := u.Exchange(new(dns.Msg).SetQuestion("wweir.cc.", dns.TypeA))
+			if addr != tt.want || (err == nil) != (tt.want != "") {
+				t.Fatalf("Upstream.Exchange() = %s, %v, want %s", addr, err, tt.want)
+			}
+			if msg != nil && msg.Rcode != dns.RcodeNameError {
+				t.Errorf("Upstream.Exchange() rcode = %d", msg.Rcode)
+			}
+		})
+	}
+}
+
+func TestUpstream_Latency(t *testing.T) {
+	ok, srv := serve(t, dns.RcodeSuccess)
+	defer srv.Shutdown()
+	fail, srv := serve(t, dns.RcodeServerFailure)
+	defer srv.Shutdown()
+
+	u, _ := NewUpstream(Latency, 500*time.Millisecond, fail, ok)
+	for i := 0; i < 3; i++ {
+		u.Exchange(new(dns.Msg).SetQuestion("wweir.cc.", dns.TypeA))
+	}
+
+	u.RLock()
+	defer u.RUnlock()
+	if u.servers[0].rtt <= u.servers[1].rtt {
+		t.Errorf("failed server rtt %d, want more than %d", u.servers[0].rtt, u.servers[1].rtt)
+	}
+}
+
+func TestUpstream_Backoff(t *testing.T) {
+	ok, srv := serve(t, dns.RcodeSuccess)
+	defer srv.Shutdown()
+	dead := func() string {
+		conn, _ := net.ListenPacket("udp", "127.0.0.1:0")
+		defer conn.Close()
+		return conn.LocalAddr().String()
+	}()
+
+	u, _ := NewUpstream(Failover, 300*time.Millisecond, dead, ok)
+	u.Exchange(new(dns.Msg).SetQuestion("wweir.cc.", dns.TypeA))
+
+	start := time.Now()
+	if _, addr, err := u.Exchange(new(dns.Msg).SetQuestion("wweir.cc.", dns.TypeA)); err != nil || addr != ok {
+		t.Fatalf("Upstream.Exchange() = %s, %v, want %s", addr, err, ok)
+	}
+	if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+		t.Errorf("down server not skipped, took %s", elapsed)
+	}
+
+	u.RLock()
+	defer u.RUnlock()
+	if u.servers[0].fails != 1 || u.servers[1].fails != 0 {
+		t.Errorf("fails = %d, %d, want 1, 0", u.servers[0].fails, u.servers[1].fails)
+	}
+}
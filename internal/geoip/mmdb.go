@@ -0,0 +1,296 @@
+// Package geoip is a minimal reader of MaxMind DB files, eg: GeoLite2-Country.mmdb
+// https://maxmind.github.io/MaxMind-DB/
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"strings"
+)
+
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+const dataSeparator = 16
+
+// Reader look up the records of ips in a MaxMind DB
+type Reader struct {
+	buf        []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipv4Start  uint
+	ipVersion  uint
+}
+
+// Open read the whole MaxMind DB file into memory
+func Open(file string) (*Reader, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return New(buf)
+}
+
+// New parse a MaxMind DB from buf
+func New(buf []byte) (*Reader, error) {
+	idx := bytes.LastIndex(buf, metadataMarker)
+	if idx < 0 {
+		return nil, errors.New("invalid MaxMind DB: metadata not found")
+	}
+
+	metaBuf := buf[idx+len(metadataMarker):]
+	val, _, err := (&decoder{metaBuf}).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("decode metadata: %w", err)
+	}
+	meta, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid MaxMind DB: metadata is not a map")
+	}
+
+	r := &Reader{buf: buf}
+	for key, ptr := range map[string]*uint{
+		"node_count":  &r.nodeCount,
+		"record_size": &r.recordSize,
+		"ip_version":  &r.ipVersion,
+	} {
+		n, ok := meta[key].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("invalid MaxMind DB: metadata %s missing", key)
+		}
+		*ptr = uint(n)
+	}
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("invalid MaxMind DB: record size %d", r.recordSize)
+	}
+
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSeparator > uint(idx) {
+		return nil, errors.New("invalid MaxMind DB: search tree out of range")
+	}
+	r.data = buf[treeSize+dataSeparator : idx]
+
+	// IPv4 addresses are stored as ::a.b.c.d in IPv6 tree
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+func (r *Reader) record(node uint, bit byte) uint {
+	b := r.buf[node*r.recordSize/4:]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Lookup return the record of ip, nil if not found
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	node, bits := uint(0), 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, node, bits = ip4, r.ipv4Start, 32
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		node = r.record(node, ip[i/8]>>uint(7-i%8)&1)
+	}
+
+	switch {
+	case node == r.nodeCount:
+		return nil, nil
+	case node < r.nodeCount:
+		return nil, errors.New("invalid MaxMind DB: search tree too deep")
+	case node < r.nodeCount+dataSeparator:
+		return nil, errors.New("invalid MaxMind DB: record points into data separator")
+	}
+
+	offset := node - r.nodeCount - dataSeparator
+	val, _, err := (&decoder{r.data}).decode(offset, 0)
+	return val, err
+}
+
+// Country return the ISO 3166-1 code of ip in upper case, empty if not found
+func (r *Reader) Country(ip net.IP) (string, error) {
+	val, err := r.Lookup(ip)
+	if err != nil || val == nil {
+		return "", err
+	}
+
+	record, _ := val.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := record[key].(map[string]interface{}); ok {
+			if code, ok := country["iso_code"].(string); ok {
+				return strings.ToUpper(code), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// Data types, https://maxmind.github.io/MaxMind-DB/#output-data-section
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+type decoder struct {
+	buf []byte
+}
+
+var errOutOfRange = errors.New("invalid MaxMind DB: data out of range")
+
+func (d *decoder) bytes(offset, size uint) ([]byte, error) {
+	if offset+size > uint(len(d.buf)) {
+		return nil, errOutOfRange
+	}
+	return d.buf[offset : offset+size], nil
+}
+
+func (d *decoder) uint(offset, size uint) (uint64, error) {
+	b, err := d.bytes(offset, size)
+	if err != nil {
+		return 0, err
+	}
+
+	var val uint64
+	for _, c := range b {
+		val = val<<8 | uint64(c)
+	}
+	return val, nil
+}
+
+// decode the value at offset, return the offset next to it
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > 32 {
+		return nil, 0, errors.New("invalid MaxMind DB: data nested too deep")
+	}
+
+	ctrl, err := d.uint(offset, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset++
+
+	typ := uint(ctrl >> 5)
+	if typ == typePointer {
+		ss, vvv := uint(ctrl>>3&3), uint64(ctrl&7)
+		ptr, err := d.uint(offset, ss+1)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch ss {
+		case 0, 1, 2:
+			ptr |= vvv << (8 * (ss + 1))
+			ptr += [...]uint64{0, 2048, 526336}[ss]
+		}
+
+		val, _, err := d.decode(uint(ptr), depth+1)
+		return val, offset + ss + 1, err
+	}
+
+	if typ == typeExtended {
+		ext, err := d.uint(offset, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ, offset = 7+uint(ext), offset+1
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		extra, err := d.uint(offset, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		size = [...]uint{29, 285, 65821}[n-1] + uint(extra)
+		offset += n
+	}
+
+	switch typ {
+	case typeString:
+		b, err := d.bytes(offset, size)
+		return string(b), offset + size, err
+	case typeBytes, typeUint128:
+		b, err := d.bytes(offset, size)
+		return b, offset + size, err
+	case typeDouble:
+		val, err := d.uint(offset, 8)
+		return math.Float64frombits(val), offset + 8, err
+	case typeFloat:
+		val, err := d.uint(offset, 4)
+		return float64(math.Float32frombits(uint32(val))), offset + 4, err
+	case typeUint16, typeUint32, typeUint64:
+		val, err := d.uint(offset, size)
+		return val, offset + size, err
+	case typeInt32:
+		val, err := d.uint(offset, size)
+		return int64(int32(val)), offset + size, err
+	case typeBool:
+		return size != 0, offset, nil
+
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("invalid MaxMind DB: map key is not a string")
+			}
+
+			if m[k], offset, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+
+	case typeArray:
+		arr := make([]interface{}, size)
+		for i := range arr {
+			if arr[i], offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return arr, offset, nil
+
+	default:
+		return nil, 0, fmt.Errorf("invalid MaxMind DB: unsupported data type %d", typ)
+	}
+}
@@ -0,0 +1,152 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func encode(typ byte, payload []byte) []byte {
+	if typ > 7 {
+		return append([]byte{byte(len(payload)), typ - 7}, payload...)
+	}
+	return append([]byte{typ<<5 | byte(len(payload))}, payload...)
+}
+
+func encodeMap(pairs ...[]byte) []byte {
+	buf := []byte{typeMap<<5 | byte(len(pairs)/2)}
+	for _, p := range pairs {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+func str(s string) []byte { return encode(typeString, []byte(s)) }
+func u16(n uint16) []byte { return encode(typeUint16, []byte{byte(n >> 8), byte(n)}) }
+func u32(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return encode(typeUint32, b)
+}
+
+// buildIPv4DB build a 24 bits record db with networks like `1.0.0.0/8` => country
+func buildIPv4DB(t *testing.T, networks map[string]string) []byte {
+	type node struct{ sub [2]interface{} } // *node or country string
+	root := &node{}
+	nodes := []*node{root}
+	for cidr, country := range networks {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := ipNet.Mask.Size()
+		cur := root
+		for i := 0; i < ones; i++ {
+			bit := ipNet.IP.To4()[i/8] >> uint(7-i%8) & 1
+			if i == ones-1 {
+				cur.sub[bit] = country
+				break
+			}
+			if next, ok := cur.sub[bit].(*node); ok {
+				cur = next
+				continue
+			}
+			next := &node{}
+			nodes = append(nodes, next)
+			cur.sub[bit], cur = next, next
+		}
+	}
+
+	// the first record shares the key by pointer
+	data, offsets := []byte{}, map[string]int{}
+	for _, country := range networks {
+		if _, ok := offsets[country]; ok {
+			continue
+		}
+		key := str("country")
+		if len(offsets) != 0 {
+			key = []byte{typePointer<<5 | 0, 1} // pointer to "country" of the first record
+		}
+		offsets[country] = len(data)
+		data = append(data, encodeMap(key, encodeMap(str("iso_code"), str(country)))...)
+	}
+
+	index := map[*node]int{}
+	for i, n := range nodes {
+		index[n] = i
+	}
+	tree := []byte{}
+	for _, n := range nodes {
+		for _, sub := range n.sub {
+			val := len(nodes) // empty
+			switch sub := sub.(type) {
+			case *node:
+				val = index[sub]
+			case string:
+				val = len(nodes) + dataSeparator + offsets[sub]
+			}
+			tree = append(tree, byte(val>>16), byte(val>>8), byte(val))
+		}
+	}
+
+	buf := bytes.NewBuffer(tree)
+	buf.Write(make([]byte, dataSeparator))
+	buf.Write(data)
+	buf.Write(metadataMarker)
+	buf.Write(encodeMap(
+		str("node_count"), u32(uint32(len(nodes))),
+		str("record_size"), u16(24),
+		str("ip_version"), u16(4),
+		str("database_type"), str("test"),
+	))
+	return buf.Bytes()
+}
+
+func TestReader_Country(t *testing.T) {
+	db, err := New(buildIPv4DB(t, map[string]string{
+		"1.0.0.0/8":    "cn",
+		"8.8.8.0/24":   "US",
+		"114.0.0.0/16": "cn",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"1.2.3.4", "CN"},
+		{"8.8.8.8", "US"},
+		{"8.8.4.4", ""},
+		{"114.0.0.1", "CN"},
+		{"2001:db8::1", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got, err := db.Country(net.ParseIP(tt.ip))
+			if err != nil || got != tt.want {
+				t.Errorf("Country() = %s, %v, want %s", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := New([]byte("not a mmdb")); err == nil {
+		t.Error("New() invalid db without error")
+	}
+}
+
+func TestReader_LookupCorrupt(t *testing.T) {
+	data := buildIPv4DB(t, map[string]string{"0.0.0.0/1": "US"})
+	data[2] = 1 + dataSeparator/2 // the record of 0.0.0.0/1 points into the data separator
+
+	db, err := New(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Lookup(net.ParseIP("1.2.3.4")); err == nil || !strings.Contains(err.Error(), "separator") {
+		t.Errorf("Lookup() = %v, want data separator error", err)
+	}
+}
@@ -18,6 +18,7 @@ const (
 	TGT_OTHER byte = iota
 	TGT_HTTP
 	TGT_HTTPS
+	TGT_DNS
 )
 
 // Write Addr
@@ -44,6 +45,7 @@ func NewTgtConn(c net.Conn, password []byte, tgtType byte, domain string, port u
 // other => type + checksum + port + domain_length ++ domain + data
 // http  => type + checksum ++ data
 // https => type + checksum + port ++ data
+// dns   => type + checksum + port + domain_length ++ domain + dns over tcp messages
 type header struct {
 	Type         byte
 	Checksum     byte
@@ -73,38 +75,46 @@ func (c *conn) Write(b []byte) (n int, err error) {
 
 // ParseAddr parse target addr from net.Conn
 func ParseAddr(conn net.Conn, password []byte) (_ net.Conn, domain string, port uint16, err error) {
+	conn, _, domain, port, err = ParseTarget(conn, password)
+	return conn, domain, port, err
+}
+
+// ParseTarget parse target type and addr from net.Conn.
+// For TGT_DNS, the addr is the dns server the client asked for, may be empty.
+func ParseTarget(conn net.Conn, password []byte) (_ net.Conn, tgtType byte, domain string, port uint16, err error) {
 	teeConn := &util.TeeConn{Conn: conn}
 	teeConn.StartOrReset()
 	defer teeConn.Stop()
 
 	head := new(header)
 	if err = binary.Read(conn, binary.BigEndian, head); err != nil {
-		return teeConn, "", 0, nil
+		return teeConn, TGT_OTHER, "", 0, nil
 	}
 	if head.Checksum != checksum(password, head.Port, head.DomainLength) {
-		return teeConn, "", 0, nil
+		return teeConn, TGT_OTHER, "", 0, nil
 	}
 
 	switch head.Type {
-	case TGT_OTHER:
+	case TGT_OTHER, TGT_DNS:
 		buf := make([]byte, int(head.DomainLength))
 		if _, err = io.ReadFull(conn, buf); err != nil {
-			return teeConn, "", 0, err
+			return teeConn, head.Type, "", 0, err
 		}
 
-		return teeConn, string(buf), head.Port, nil
+		return teeConn, head.Type, string(buf), head.Port, nil
 
 	case TGT_HTTP:
 		teeConn.DropAndRestart()
-		return ParseHTTP(teeConn)
+		conn, domain, port, err = ParseHTTP(teeConn)
+		return conn, head.Type, domain, port, err
 
 	case TGT_HTTPS:
 		teeConn.DropAndRestart()
 		conn, domain, err = ParseHTTPS(teeConn)
-		return conn, domain, head.Port, err
+		return conn, head.Type, domain, head.Port, err
 
 	default:
-		return teeConn, "", 0, errors.New("invalid request")
+		return teeConn, head.Type, "", 0, errors.New("invalid request")
 	}
 }
 func ParseHTTP(teeConn net.Conn) (_ net.Conn, domain string, port uint16, err error) {
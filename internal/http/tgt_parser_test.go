@@ -3,6 +3,7 @@ package http
 import (
 	"bufio"
 	"bytes"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -64,3 +65,22 @@ func TestParseAddr3(t *testing.T) {
 		t.Error(err, host, port)
 	}
 }
+
+func TestParseTarget(t *testing.T) {
+	c1, c2 := net.Pipe()
+
+	go func() {
+		c1 = NewTgtConn(c1, nil, TGT_DNS, "8.8.8.8", 53)
+		c1.Write([]byte{0, 1, 2})
+	}()
+
+	c2, typ, host, port, err := ParseTarget(c2, nil)
+	if err != nil || typ != TGT_DNS || host != "8.8.8.8" || port != 53 {
+		t.Error(err, typ, host, port)
+	}
+
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(c2, buf); err != nil || buf[2] != 2 {
+		t.Error(err, buf)
+	}
+}
@@ -1,6 +1,7 @@
 package net
 
 import (
+	"bytes"
 	"math/rand"
 	"net"
 	"runtime"
@@ -11,49 +12,64 @@ import (
 	"github.com/pkg/errors"
 )
 
-var xid = make([]byte, 4)
 var broadcastAddr, _ = net.ResolveUDPAddr("udp", "255.255.255.255:67")
 
-func GetDefaultDNSServer() (string, error) {
+// GetDHCPv4DNSServers broadcast a DHCP DISCOVER and pick DNS servers from the offer
+func GetDHCPv4DNSServers() ([]string, error) {
 	iface, err := PickInternetInterface()
 	if err != nil {
-		return "", errors.Wrap(err, "pick interface")
+		return nil, errors.Wrap(err, "pick interface")
 	}
 
-	rand.Read(xid)
-	pack := dhcp4.RequestPacket(dhcp4.Discover, iface.HardwareAddr, net.IPv4(0, 0, 0, 0), xid, true, []dhcp4.Option{
-		{Code: dhcp4.OptionRequestedIPAddress, Value: []byte(iface.IP.To4())},
-		{Code: dhcp4.End},
-	})
-
 	var conn net.PacketConn
 	if runtime.GOOS == "windows" {
 		if conn, err = reuseport.ListenPacket("udp4", iface.IP.String()+":68"); err != nil {
-			return "", errors.Wrap(err, "listen dhcp")
+			return nil, errors.Wrap(err, "listen dhcp")
 		}
 	} else {
 		if conn, err = reuseport.ListenPacket("udp4", "0.0.0.0:68"); err != nil {
-			return "", errors.Wrap(err, "listen dhcp")
+			return nil, errors.Wrap(err, "listen dhcp")
 		}
 	}
 	defer conn.Close()
 	conn.SetDeadline(time.Now().Add(3 * time.Second))
 
-	if _, err := conn.WriteTo([]byte(pack), broadcastAddr); err != nil {
-		return "", errors.Wrap(err, "write broadcast")
+	return dhcpv4DNSServers(conn, broadcastAddr, iface)
+}
+
+func dhcpv4DNSServers(conn net.PacketConn, dst net.Addr, iface *Iface) ([]string, error) {
+	xid := make([]byte, 4)
+	rand.Read(xid)
+	pack := dhcp4.RequestPacket(dhcp4.Discover, iface.HardwareAddr, net.IPv4(0, 0, 0, 0), xid, true, []dhcp4.Option{
+		{Code: dhcp4.OptionRequestedIPAddress, Value: []byte(iface.IP.To4())},
+		{Code: dhcp4.End},
+	})
+
+	if _, err := conn.WriteTo([]byte(pack), dst); err != nil {
+		return nil, errors.Wrap(err, "write broadcast")
 	}
 
 	buf := make([]byte, 1500 /*MTU*/)
-	n, _, err := conn.ReadFrom(buf)
-	if err != nil {
-		return "", errors.Wrap(err, "read dhcp offer")
-	}
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read dhcp offer")
+		}
 
-	pack = dhcp4.Packet(buf[:n])
-	dnsBytes := pack.ParseOptions()[dhcp4.OptionDomainNameServer]
-	if len(dnsBytes) < 4 {
-		return "", errors.New("no DNS setting in upstream network device")
-	}
+		pack = dhcp4.Packet(buf[:n])
+		if n < 240 || pack.OpCode() != dhcp4.BootReply || !bytes.Equal(pack.XId(), xid) {
+			continue // not the reply for us
+		}
+
+		dnsBytes := pack.ParseOptions()[dhcp4.OptionDomainNameServer]
+		if len(dnsBytes) < 4 {
+			return nil, errors.New("no DNS setting in upstream network device")
+		}
 
-	return net.IPv4(dnsBytes[0], dnsBytes[1], dnsBytes[2], dnsBytes[3]).String(), nil
+		servers := make([]string, 0, len(dnsBytes)/4)
+		for i := 0; i+4 <= len(dnsBytes); i += 4 {
+			servers = append(servers, net.IP(dnsBytes[i:i+4]).String())
+		}
+		return servers, nil
+	}
 }
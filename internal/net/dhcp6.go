@@ -0,0 +1,119 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-reuseport"
+	"github.com/pkg/errors"
+)
+
+// https://tools.ietf.org/html/rfc8415
+const (
+	dhcpv6InformationRequest = 11
+	dhcpv6Reply              = 7
+
+	dhcpv6OptionClientID    = 1
+	dhcpv6OptionORO         = 6
+	dhcpv6OptionElapsedTime = 8
+	dhcpv6OptionDNSServers  = 23 // https://tools.ietf.org/html/rfc3646
+)
+
+// GetDHCPv6DNSServers send a DHCPv6 INFORMATION-REQUEST and pick DNS servers from the reply
+func GetDHCPv6DNSServers() ([]string, error) {
+	iface, err := pickIPv6Interface()
+	if err != nil {
+		return nil, errors.Wrap(err, "pick interface")
+	}
+
+	conn, err := reuseport.ListenPacket("udp6", "[::]:546")
+	if err != nil {
+		return nil, errors.Wrap(err, "listen dhcpv6")
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	// All_DHCP_Relay_Agents_and_Servers
+	dst := &net.UDPAddr{IP: net.ParseIP("ff02::1:2"), Port: 547, Zone: iface.Name}
+	return dhcpv6DNSServers(conn, dst, iface.HardwareAddr)
+}
+
+func pickIPv6Interface() (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagUp == 0 || len(ifaces[i].HardwareAddr) == 0 {
+			continue
+		}
+
+		addrs, _ := ifaces[i].Addrs()
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() == nil {
+				return &ifaces[i], nil
+			}
+		}
+	}
+	return nil, errors.New("no valid ipv6 interface")
+}
+
+func dhcpv6DNSServers(conn net.PacketConn, dst net.Addr, hwAddr net.HardwareAddr) ([]string, error) {
+	xid := make([]byte, 3)
+	rand.Read(xid)
+
+	// DUID-LL, https://tools.ietf.org/html/rfc8415#section-11.4
+	duid := append([]byte{0, 3, 0, 1}, hwAddr...)
+	pack := append([]byte{dhcpv6InformationRequest}, xid...)
+	pack = appendDHCPv6Option(pack, dhcpv6OptionClientID, duid)
+	pack = appendDHCPv6Option(pack, dhcpv6OptionORO, []byte{0, dhcpv6OptionDNSServers})
+	pack = appendDHCPv6Option(pack, dhcpv6OptionElapsedTime, []byte{0, 0})
+
+	if _, err := conn.WriteTo(pack, dst); err != nil {
+		return nil, errors.Wrap(err, "write information request")
+	}
+
+	buf := make([]byte, 1500 /*MTU*/)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, errors.Wrap(err, "read dhcpv6 reply")
+		}
+		if n < 4 || buf[0] != dhcpv6Reply || !bytes.Equal(buf[1:4], xid) {
+			continue // not the reply for us
+		}
+
+		for opts := buf[4:n]; len(opts) >= 4; {
+			code := binary.BigEndian.Uint16(opts[:2])
+			length := int(binary.BigEndian.Uint16(opts[2:4]))
+			if len(opts) < 4+length {
+				break
+			}
+			val := opts[4 : 4+length]
+			opts = opts[4+length:]
+
+			if code != dhcpv6OptionDNSServers {
+				continue
+			}
+			servers := make([]string, 0, len(val)/net.IPv6len)
+			for i := 0; i+net.IPv6len <= len(val); i += net.IPv6len {
+				servers = append(servers, net.IP(val[i:i+net.IPv6len]).String())
+			}
+			if len(servers) != 0 {
+				return servers, nil
+			}
+		}
+		return nil, errors.New("no DNS setting in dhcpv6 reply")
+	}
+}
+
+func appendDHCPv6Option(pack []byte, code uint16, val []byte) []byte {
+	head := make([]byte, 4)
+	binary.BigEndian.PutUint16(head[:2], code)
+	binary.BigEndian.PutUint16(head[2:], uint16(len(val)))
+	return append(append(pack, head...), val...)
+}
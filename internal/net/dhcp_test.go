@@ -1,15 +1,88 @@
-package net_test
+package net
 
 import (
 	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
 
-	"github.com/wweir/sower/internal/net"
+	"github.com/krolaw/dhcp4"
 )
 
 func Example_dns() {
-	got, err := net.GetDefaultDNSServer()
+	got, err := GetDefaultDNSServers()
 	if err != nil {
 		panic(err)
 	}
 	fmt.Println(got)
 }
+
+// respond answer the first packet received by conn with the reply built from it
+func respond(t *testing.T, conn net.PacketConn, reply func(req []byte) []byte) {
+	buf := make([]byte, 1500)
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.WriteTo([]byte("noise"), addr)
+	conn.WriteTo(reply(buf[:n]), addr)
+}
+
+func listen(t *testing.T, network, addr string) (server, client net.PacketConn) {
+	server, err := net.ListenPacket(network, addr)
+	if err != nil {
+		t.Skip(err)
+	}
+	if client, err = net.ListenPacket(network, addr); err != nil {
+		t.Skip(err)
+	}
+	client.SetDeadline(time.Now().Add(time.Second))
+	return server, client
+}
+
+func TestDHCPv4DNSServers(t *testing.T) {
+	server, client := listen(t, "udp4", "127.0.0.1:0")
+	defer server.Close()
+	defer client.Close()
+
+	go respond(t, server, func(req []byte) []byte {
+		pack := dhcp4.Packet(req)
+		return dhcp4.ReplyPacket(pack, dhcp4.Offer, net.IPv4(192, 168, 1, 1), net.IPv4(192, 168, 1, 100), time.Hour,
+			[]dhcp4.Option{{Code: dhcp4.OptionDomainNameServer, Value: []byte{192, 168, 1, 1, 8, 8, 8, 8}}})
+	})
+
+	iface := &Iface{HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}, IP: net.IPv4(192, 168, 1, 100)}
+	got, err := dhcpv4DNSServers(client, server.LocalAddr(), iface)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"192.168.1.1", "8.8.8.8"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dhcpv4DNSServers() = %v, want %v", got, want)
+	}
+}
+
+func TestDHCPv6DNSServers(t *testing.T) {
+	server, client := listen(t, "udp6", "[::1]:0")
+	defer server.Close()
+	defer client.Close()
+
+	go respond(t, server, func(req []byte) []byte {
+		if req[0] != dhcpv6InformationRequest {
+			t.Errorf("message type = %d, want information request", req[0])
+		}
+		reply := append([]byte{dhcpv6Reply}, req[1:4]...)
+		reply = appendDHCPv6Option(reply, dhcpv6OptionClientID, []byte{0, 3, 0, 1, 0, 1, 2, 3, 4, 5})
+		val := append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...)
+		return appendDHCPv6Option(reply, dhcpv6OptionDNSServers, val)
+	})
+
+	got, err := dhcpv6DNSServers(client, server.LocalAddr(), net.HardwareAddr{0, 1, 2, 3, 4, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"2001:db8::1", "2001:db8::2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dhcpv6DNSServers() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package net
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	resolvConf   = "/etc/resolv.conf"
+	resolvedConf = "/run/systemd/resolve/resolv.conf" // upstream servers of systemd-resolved
+	resolvedStub = net.IPv4(127, 0, 0, 53)
+)
+
+// GetDefaultDNSServers discover the upstream DNS servers of the system, try in order:
+// resolv.conf, systemd-resolved, DHCPv4, DHCPv6. The ips in skip, eg: sower itself, are ignored.
+func GetDefaultDNSServers(skip ...string) ([]string, error) {
+	sources := []struct {
+		name string
+		fn   func() ([]string, error)
+	}{
+		{"resolv.conf", func() ([]string, error) { return parseResolvConf(resolvConf) }},
+		{"systemd-resolved", func() ([]string, error) { return parseResolvConf(resolvedConf) }},
+		{"dhcpv4", GetDHCPv4DNSServers},
+		{"dhcpv6", GetDHCPv6DNSServers},
+	}
+
+	errs := []string{}
+	for _, source := range sources {
+		servers, err := source.fn()
+		if err != nil {
+			errs = append(errs, source.name+": "+err.Error())
+			continue
+		}
+
+		if servers = filterServers(servers, skip); len(servers) != 0 {
+			return servers, nil
+		}
+		errs = append(errs, source.name+": no valid server")
+	}
+	return nil, errors.Errorf("no upstream dns server found: %s", strings.Join(errs, "; "))
+}
+
+func parseResolvConf(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	servers := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if ip := net.ParseIP(strings.SplitN(fields[1], "%", 2)[0]); ip != nil {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers, scanner.Err()
+}
+
+func filterServers(servers, skip []string) []string {
+	filtered := make([]string, 0, len(servers))
+NEXT:
+	for _, server := range servers {
+		ip := net.ParseIP(strings.SplitN(server, "%", 2)[0])
+		if ip == nil || ip.Equal(resolvedStub) {
+			continue
+		}
+		for _, s := range skip {
+			if ip.Equal(net.ParseIP(s)) {
+				continue NEXT
+			}
+		}
+		filtered = append(filtered, server)
+	}
+	return filtered
+}
@@ -0,0 +1,37 @@
+package net
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetDefaultDNSServers(t *testing.T) {
+	defer func(conf, resolved string) { resolvConf, resolvedConf = conf, resolved }(resolvConf, resolvedConf)
+
+	tests := []struct {
+		name     string
+		conf     string
+		resolved string
+		skip     []string
+		want     []string
+	}{
+		{"resolv.conf", "testdata/resolv.conf", "testdata/not_exist", nil,
+			[]string{"192.168.1.1", "fe80::1%eth0", "127.0.0.1"}},
+		{"skip_self", "testdata/resolv.conf", "testdata/not_exist", []string{"127.0.0.1"},
+			[]string{"192.168.1.1", "fe80::1%eth0"}},
+		{"systemd-resolved", "testdata/resolv.stub.conf", "testdata/resolv.conf", []string{"127.0.0.1"},
+			[]string{"192.168.1.1", "fe80::1%eth0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolvConf, resolvedConf = tt.conf, tt.resolved
+			got, err := GetDefaultDNSServers(tt.skip...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetDefaultDNSServers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package rule
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Formats of rule lists
+const (
+	FormatAuto    = ""        // hosts / AdBlock / plain domains, see Parse
+	FormatGFWList = "gfwlist" // base64 encoded AdBlock syntax
+	FormatDnsmasq = "dnsmasq" // server=/domain/upstream, ipset=/domain/set ...
+	FormatClash   = "clash"   // rule provider payload, eg: DOMAIN-SUFFIX,domain / +.domain
+	FormatPlain   = "plain"   // one domain per line, sub domains included
+)
+
+// SplitFormat split the format prefixed to a file, eg: gfwlist:/etc/sower/gfwlist.txt.
+// The format is empty if the file is not prefixed by a known one.
+func SplitFormat(spec string) (format, file string) {
+	if idx := strings.IndexByte(spec, ':'); idx > 0 {
+		switch format := spec[:idx]; format {
+		case FormatGFWList, FormatDnsmasq, FormatClash, FormatPlain:
+			return format, spec[idx+1:]
+		}
+	}
+	return FormatAuto, spec
+}
+
+// ParseFileFormat translate a rule list file in format into util.Node rules, see ParseFormat
+func ParseFileFormat(file, format string) (rules, bad []string, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	return ParseFormat(f, format)
+}
+
+// ParseFormat translate a rule list in format into util.Node rules.
+// Lines could not be translated are returned as bad.
+func ParseFormat(r io.Reader, format string) (rules, bad []string, err error) {
+	var parseLine func(line string) []string
+	switch format {
+	case FormatAuto:
+		return Parse(r)
+	case FormatGFWList:
+		if r, err = decodeBase64(r); err != nil {
+			return nil, nil, err
+		}
+		parseLine = parseGFWList
+	case FormatDnsmasq:
+		parseLine = parseDnsmasq
+	case FormatClash:
+		parseLine = parseClash
+	case FormatPlain:
+		parseLine = parsePlain
+	default:
+		return nil, nil, fmt.Errorf("unknown rule list format: %s", format)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+
+		if translated := parseLine(line); translated != nil {
+			rules = append(rules, translated...)
+		} else {
+			bad = append(bad, line)
+		}
+	}
+	return rules, bad, scanner.Err()
+}
+
+// decodeBase64 decode the whole list, keep it as is if it is not base64 encoded
+func decodeBase64(r io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	text := strings.Map(func(c rune) rune {
+		if c == '\r' || c == '\n' || c == ' ' || c == '\t' {
+			return -1
+		}
+		return c
+	}, string(data))
+	if decoded, err := base64.StdEncoding.DecodeString(text); err == nil {
+		return bytes.NewReader(decoded), nil
+	}
+	if decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(text, "=")); err == nil {
+		return bytes.NewReader(decoded), nil
+	}
+	return bytes.NewReader(data), nil
+}
+
+// parseGFWList translate the url patterns into the rules of their hosts,
+// exceptions `@@` and regular expressions `/.../` are not supported
+func parseGFWList(line string) []string {
+	switch {
+	case strings.HasPrefix(line, "@@"), strings.HasPrefix(line, "/"):
+		return nil
+
+	case strings.HasPrefix(line, "||"):
+		if host := gfwHost(line[2:]); host != "" {
+			return []string{"**." + host}
+		}
+
+	case strings.HasPrefix(line, "|"):
+		u, err := url.Parse(line[1:])
+		if err != nil || u.Hostname() == "" {
+			return nil
+		}
+		if host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "*."); isDomain(host) {
+			if host != strings.ToLower(u.Hostname()) {
+				return []string{"**." + host}
+			}
+			return []string{host}
+		}
+
+	default:
+		// keyword in url, approximate by the host and its sub domains
+		if host := gfwHost(strings.TrimPrefix(line, ".")); host != "" {
+			return []string{"**." + host}
+		}
+	}
+	return nil
+}
+
+func gfwHost(pattern string) string {
+	if idx := strings.IndexAny(pattern, "/^:"); idx >= 0 {
+		pattern = pattern[:idx]
+	}
+	pattern = strings.ToLower(strings.TrimPrefix(pattern, "*."))
+	if !isDomain(pattern) {
+		return ""
+	}
+	return pattern
+}
+
+// parseDnsmasq translate the domains of `option=/domain/.../value`
+func parseDnsmasq(line string) []string {
+	idx := strings.IndexByte(line, '=')
+	if idx <= 0 {
+		return nil
+	}
+	switch line[:idx] {
+	case "server", "local", "address", "ipset", "nftset":
+	default:
+		return nil
+	}
+
+	secs := strings.Split(line[idx+1:], "/")
+	if len(secs) < 3 || secs[0] != "" {
+		return nil
+	}
+
+	rules := []string{}
+	for _, domain := range secs[1 : len(secs)-1] {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if !isDomain(domain) {
+			return nil
+		}
+		rules = append(rules, "**."+domain)
+	}
+	return rules
+}
+
+// parseClash translate the domain rules of classical and domain behavior providers
+func parseClash(line string) []string {
+	if line == "payload:" {
+		return []string{}
+	}
+	line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+	line = strings.Trim(line, `'"`)
+
+	if fields := strings.Split(line, ","); len(fields) >= 2 {
+		domain := strings.ToLower(strings.TrimSpace(fields[1]))
+		if !isDomain(domain) {
+			return nil
+		}
+		switch strings.TrimSpace(fields[0]) {
+		case "DOMAIN":
+			return []string{domain}
+		case "DOMAIN-SUFFIX":
+			return []string{"**." + domain}
+		default:
+			return nil // DOMAIN-KEYWORD, IP-CIDR ...
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(line, "+."):
+		if domain := strings.ToLower(line[2:]); isDomain(domain) {
+			return []string{"**." + domain}
+		}
+	case strings.HasPrefix(line, "*."):
+		if domain := strings.ToLower(line[2:]); isDomain(domain) {
+			return []string{"*." + domain}
+		}
+	case isDomain(line):
+		return []string{strings.ToLower(line)}
+	}
+	return nil
+}
+
+// parsePlain translate domains with sub domains, keep the util.Node wildcard rules
+func parsePlain(line string) []string {
+	if idx := strings.IndexByte(line, '#'); idx > 0 {
+		line = strings.TrimSpace(line[:idx])
+	}
+	line = strings.ToLower(line)
+
+	if strings.HasPrefix(line, "*.") || strings.HasPrefix(line, "**.") {
+		if isDomain(strings.TrimLeft(line, "*.")) {
+			return []string{line}
+		}
+		return nil
+	}
+	if domain := strings.TrimPrefix(line, "."); isDomain(domain) {
+		return []string{"**." + domain}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package rule
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	gfwlist := `[AutoProxy 0.2.9]
+! comment
+||google.com
+||blogspot.com^
+|https://*.wikipedia.org/wiki
+|http://85.17.73.31/
+.twimg.com
+example.org/path
+@@||cn.example.com
+/^https?:\/\/[^\/]+blogspot\.(.*)/
+`
+	tests := []struct {
+		name     string
+		format   string
+		list     string
+		wantRule []string
+		wantBad  []string
+	}{{
+		name:     "gfwlist",
+		format:   FormatGFWList,
+		list:     base64.StdEncoding.EncodeToString([]byte(gfwlist)),
+		wantRule: []string{"**.google.com", "**.blogspot.com", "**.wikipedia.org", "85.17.73.31", "**.twimg.com", "**.example.org"},
+		wantBad:  []string{"@@||cn.example.com", `/^https?:\/\/[^\/]+blogspot\.(.*)/`},
+	}, {
+		name:     "gfwlist_plain_text",
+		format:   FormatGFWList,
+		list:     "||google.com\n",
+		wantRule: []string{"**.google.com"},
+	}, {
+		name:   "dnsmasq",
+		format: FormatDnsmasq,
+		list: `# dnsmasq-china-list
+server=/qq.com/114.114.114.114
+ipset=/.taobao.com/tmall.com/china
+address=/ad.example.com/0.0.0.0
+server=114.114.114.114
+conf-dir=/etc/dnsmasq.d`,
+		wantRule: []string{"**.qq.com", "**.taobao.com", "**.tmall.com", "**.ad.example.com"},
+		wantBad:  []string{"server=114.114.114.114", "conf-dir=/etc/dnsmasq.d"},
+	}, {
+		name:   "clash",
+		format: FormatClash,
+		list: `payload:
+  # classical
+  - DOMAIN-SUFFIX,google.com
+  - DOMAIN,www.example.com,Proxy
+  - DOMAIN-KEYWORD,youtube
+  - IP-CIDR,1.1.1.1/32
+  # domain behavior
+  - '+.github.com'
+  - "*.githubusercontent.com"
+  - 'api.example.org'`,
+		wantRule: []string{"**.google.com", "www.example.com", "**.github.com", "*.githubusercontent.com", "api.example.org"},
+		wantBad:  []string{"- DOMAIN-KEYWORD,youtube", "- IP-CIDR,1.1.1.1/32"},
+	}, {
+		name:     "plain",
+		format:   FormatPlain,
+		list:     "google.com\n.youtube.com # comment\n*.github.io\n**.x.com\nlocalhost\n",
+		wantRule: []string{"**.google.com", "**.youtube.com", "*.github.io", "**.x.com"},
+		wantBad:  []string{"localhost"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, bad, err := ParseFormat(strings.NewReader(tt.list), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rules, tt.wantRule) {
+				t.Errorf("ParseFormat() rules = %v, want %v", rules, tt.wantRule)
+			}
+			if !reflect.DeepEqual(bad, tt.wantBad) {
+				t.Errorf("ParseFormat() bad = %v, want %v", bad, tt.wantBad)
+			}
+		})
+	}
+
+	if _, _, err := ParseFormat(strings.NewReader(""), "unknown"); err == nil {
+		t.Error("ParseFormat() unknown format without error")
+	}
+}
+
+func TestSplitFormat(t *testing.T) {
+	tests := []struct {
+		spec, format, file string
+	}{
+		{"gfwlist:/etc/sower/gfwlist.txt", FormatGFWList, "/etc/sower/gfwlist.txt"},
+		{"dnsmasq:ads.conf", FormatDnsmasq, "ads.conf"},
+		{"/etc/hosts", FormatAuto, "/etc/hosts"},
+		{`C:\sower\hosts`, FormatAuto, `C:\sower\hosts`},
+	}
+	for _, tt := range tests {
+		if format, file := SplitFormat(tt.spec); format != tt.format || file != tt.file {
+			t.Errorf("SplitFormat(%s) = %q, %q, want %q, %q", tt.spec, format, file, tt.format, tt.file)
+		}
+	}
+}
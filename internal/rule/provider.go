@@ -0,0 +1,123 @@
+package rule
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/wweir/sower/util"
+)
+
+// Provider keep the rules from a file or an http url up to date.
+// The rules are swapped atomically, so the last good copy stays on failure.
+type Provider struct {
+	Source string // file path or http(s) url
+	Format string
+	Cache  string // keep the last good copy of url source, for offline start
+	Client *http.Client
+
+	node atomic.Value // *util.Node
+}
+
+// NewProvider create a provider, client is used to fetch url source
+func NewProvider(source, format, cache string, client *http.Client) *Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Provider{Source: source, Format: format, Cache: cache, Client: client}
+}
+
+func (p *Provider) isURL() bool {
+	return strings.HasPrefix(p.Source, "http://") || strings.HasPrefix(p.Source, "https://")
+}
+
+// Load the rules from cache if exists, or fetch from source
+func (p *Provider) Load() (bad []string, err error) {
+	if bad, err = p.LoadCache(); err == nil {
+		return bad, nil
+	}
+	return p.Refresh()
+}
+
+// LoadCache load the rules from the last good copy of url source, never fetch
+func (p *Provider) LoadCache() (bad []string, err error) {
+	if !p.isURL() || p.Cache == "" {
+		return nil, errors.New("no cache of " + p.Source)
+	}
+
+	data, err := ioutil.ReadFile(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return p.swap(data)
+}
+
+// Refresh fetch and validate the rules, then swap the active ones
+func (p *Provider) Refresh() (bad []string, err error) {
+	data, err := p.fetch()
+	if err != nil {
+		return nil, err
+	}
+	if bad, err = p.swap(data); err != nil {
+		return bad, err
+	}
+
+	if p.isURL() && p.Cache != "" {
+		if err := writeFile(p.Cache, data); err != nil {
+			return bad, fmt.Errorf("write cache: %w", err)
+		}
+	}
+	return bad, nil
+}
+
+func (p *Provider) fetch() ([]byte, error) {
+	if !p.isURL() {
+		return ioutil.ReadFile(p.Source)
+	}
+
+	resp, err := p.Client.Get(p.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch %s: %s", p.Source, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (p *Provider) swap(data []byte) (bad []string, err error) {
+	rules, bad, err := ParseFormat(bytes.NewReader(data), p.Format)
+	if err != nil {
+		return bad, err
+	}
+	if len(rules) == 0 {
+		return bad, errors.New("no rule found")
+	}
+	if len(bad) > len(rules) {
+		return bad, fmt.Errorf("%d of %d lines untranslatable, may not in %q format", len(bad), len(bad)+len(rules), p.Format)
+	}
+
+	p.node.Store(util.NewNodeFromRules(rules...))
+	return bad, nil
+}
+
+// Match check if the domain matches the active rules
+func (p *Provider) Match(domain string) bool {
+	node, _ := p.node.Load().(*util.Node)
+	return node.Match(domain)
+}
+
+// writeFile write file safely
+func writeFile(file string, data []byte) error {
+	if err := ioutil.WriteFile(file+"~", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(file+"~", file)
+}
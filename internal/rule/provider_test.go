@@ -0,0 +1,73 @@
+package rule
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := filepath.Join(dir, "list.cache")
+
+	body := atomic.Value{}
+	body.Store("google.com\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch b := body.Load().(string); b {
+		case "":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(b))
+		}
+	}))
+
+	p := NewProvider(srv.URL, FormatPlain, cache, srv.Client())
+	if _, err := p.LoadCache(); err == nil || p.Match("www.google.com") {
+		t.Error("LoadCache() without cache file")
+	}
+	if _, err := p.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Match("www.google.com") {
+		t.Error("Match() after load = false")
+	}
+
+	body.Store("github.com\n")
+	if _, err := p.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Match("www.google.com") || !p.Match("github.com") {
+		t.Error("Match() not swapped after refresh")
+	}
+
+	for _, b := range []string{"", "<html>\n<body>\nnot found\n</body>\n</html>\n"} {
+		body.Store(b)
+		if _, err := p.Refresh(); err == nil {
+			t.Errorf("Refresh() with invalid response %q without error", b)
+		}
+		if !p.Match("github.com") {
+			t.Error("last good rules lost on failure")
+		}
+	}
+
+	// offline start from cache
+	srv.Close()
+	p = NewProvider(srv.URL, FormatPlain, cache, nil)
+	if _, err := p.LoadCache(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Match("api.github.com") {
+		t.Error("Match() after load from cache = false")
+	}
+	if _, err := p.Refresh(); err == nil {
+		t.Error("Refresh() offline without error")
+	}
+}
@@ -0,0 +1,96 @@
+package rule
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+)
+
+// reserved names in hosts files, should never be treated as rules
+var reserved = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// Parse translate block list lines into util.Node rules, support lines in
+// hosts format `0.0.0.0 ad.example.com`, AdBlock `||example.com^` and plain domains.
+// Lines could not be translated are returned as bad.
+func Parse(r io.Reader) (rules, bad []string, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue // comment or adblock header
+		}
+
+		if translated := parseLine(line); translated != nil {
+			rules = append(rules, translated...)
+		} else {
+			bad = append(bad, line)
+		}
+	}
+	return rules, bad, scanner.Err()
+}
+
+func parseLine(line string) []string {
+	if idx := strings.IndexByte(line, '#'); idx > 0 {
+		line = strings.TrimSpace(line[:idx])
+	}
+
+	// adblock, domain and sub domains
+	if strings.HasPrefix(line, "||") {
+		if domain := strings.TrimSuffix(line[2:], "^"); len(domain) != len(line)-2 && isDomain(domain) {
+			return []string{"**." + strings.ToLower(domain)}
+		}
+		return nil
+	}
+
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 1 && isDomain(fields[0]):
+		return []string{strings.ToLower(fields[0])}
+
+	case len(fields) >= 2 && net.ParseIP(fields[0]) != nil:
+		rules := make([]string, 0, len(fields)-1)
+		for _, field := range fields[1:] {
+			field = strings.ToLower(field)
+			if reserved[field] {
+				continue
+			}
+			if !isDomain(field) {
+				return nil
+			}
+			rules = append(rules, field)
+		}
+		return rules
+
+	default:
+		return nil
+	}
+}
+
+func isDomain(domain string) bool {
+	if !strings.Contains(domain, ".") || domain[0] == '.' || domain[len(domain)-1] == '.' {
+		return false
+	}
+	for _, c := range domain {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '.', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
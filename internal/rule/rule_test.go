@@ -0,0 +1,35 @@
+package rule
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	list := `[Adblock Plus 2.0]
+! comment
+# comment
+127.0.0.1 localhost
+::1 localhost ip6-localhost ip6-loopback
+0.0.0.0 ad.example.com tracker.example.com # trailing comment
+||Ads.Example.org^
+||ads.example.net^$third-party
+@@||good.example.org^
+banner.example.com
+not a rule
+`
+	rules, bad, err := Parse(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRules := []string{"ad.example.com", "tracker.example.com", "**.ads.example.org", "banner.example.com"}
+	if !reflect.DeepEqual(rules, wantRules) {
+		t.Errorf("Parse() rules = %v, want %v", rules, wantRules)
+	}
+	wantBad := []string{"||ads.example.net^$third-party", "@@||good.example.org^", "not a rule"}
+	if !reflect.DeepEqual(bad, wantBad) {
+		t.Errorf("Parse() bad = %v, want %v", bad, wantBad)
+	}
+}
@@ -3,24 +3,39 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/wweir/sower/conf"
 	"github.com/wweir/sower/proxy"
 )
 
 func main() {
+	switch flag.Arg(0) {
+	case "explain":
+		explain(flag.Args()[1:])
+		return
+	case "aggregate":
+		aggregate(flag.Args()[1:])
+		return
+	}
+
 	if conf.Server.Upstream != "" {
 		proxy.StartServer(conf.Server.Upstream, conf.Password,
 			conf.Server.CertFile, conf.Server.KeyFile, conf.Server.CertEmail)
 	}
 
 	if conf.Client.Address != "" {
-		if conf.Client.DNS.ServeIP != "" {
-			go proxy.StartDNS(conf.Client.DNS.ServeIP, conf.Client.DNS.Upstream)
+		if conf.Client.Status.Address != "" {
+			go proxy.StartStatus(conf.Client.Status.Address)
+		}
+		if conf.Client.DNS.ServeIP != "" || conf.Client.DNS.ServeIP6 != "" {
+			go proxy.StartDNS(conf.Client.DNS.ServeIP, conf.Client.DNS.ServeIP6, conf.Client.DNS.Upstream)
 		}
 
-		proxy.StartClient(conf.Password, conf.Client.Address, conf.Client.HTTPProxy.Address,
-			conf.Client.DNS.ServeIP, conf.Client.Router.PortMapping)
+		proxy.StartClient(conf.Client.HTTPProxy.Address,
+			[]string{conf.Client.DNS.ServeIP, conf.Client.DNS.ServeIP6}, conf.Client.Router.PortMapping)
 	}
 
 	if conf.Server.Upstream == "" && conf.Client.Address == "" {
@@ -28,3 +43,42 @@ func main() {
 		flag.Usage()
 	}
 }
+
+// explain print how a domain is routed, eg: sower -f sower.toml explain -detect https://wweir.cc
+func explain(args []string) {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	detect := fs.Bool("detect", false, "detect the domain again, without changing any rule")
+	inbound := fs.String("inbound", conf.InboundHTTPProxy, "inbound matched by router rules: http_proxy / dns / port_mapping / fake_ip")
+	client := fs.String("client", "", "source ip matched by client.router.clients, eg: 192.168.1.20")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sower [-f config] explain [-detect] [-inbound name] [-client ip] <domain|url>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := conf.Explain(os.Stdout, *inbound, *client, fs.Arg(0), *detect); err != nil {
+		fmt.Fprintln(os.Stderr, "explain:", err)
+		os.Exit(1)
+	}
+}
+
+// aggregate print the wildcard rules proposed for dynamic list, eg: sower -f sower.toml aggregate -min 3
+func aggregate(args []string) {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	min := fs.Int("min", 0, "siblings required to aggregate, dynamic_aggregate or 2 if 0")
+	fs.Parse(args)
+
+	aggs := conf.AggregateDynamic(*min)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, agg := range aggs {
+		fmt.Fprintf(w, "%s\t<= %s\n", agg.Rule, strings.Join(agg.Covered, " "))
+	}
+	w.Flush()
+	if len(aggs) == 0 {
+		fmt.Println("nothing to aggregate")
+	}
+}
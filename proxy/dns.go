@@ -1,29 +1,167 @@
 package proxy
 
 import (
+	"expvar"
 	"net"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/wweir/sower/conf"
+	_dns "github.com/wweir/sower/internal/dns"
+	_http "github.com/wweir/sower/internal/http"
 	_net "github.com/wweir/sower/internal/net"
+	"github.com/wweir/sower/internal/socks5"
+	"github.com/wweir/sower/util"
 	"github.com/wweir/utils/log"
 )
 
-func StartDNS(redirectIP, relayServer string) {
-	serveIP := net.ParseIP(redirectIP)
-	if redirectIP == "" || serveIP.String() != redirectIP {
-		log.Fatalw("invalid listen ip", "ip", redirectIP)
+func StartDNS(redirectIP, redirectIP6, relayServers string) {
+	var serveIP, serveIP6 net.IP
+	for _, addr := range []string{redirectIP, redirectIP6} {
+		if addr == "" {
+			continue
+		}
+
+		switch ip := net.ParseIP(addr); {
+		case ip == nil:
+			log.Fatalw("invalid listen ip", "ip", addr)
+		case ip.To4() != nil:
+			serveIP = ip
+		default:
+			serveIP6 = ip
+		}
 	}
 
-	var err error
-	if relayServer, err = pickRelayAddr(relayServer); err != nil {
+	addrs, err := pickRelayAddrs(relayServers, redirectIP, redirectIP6)
+	if err != nil {
 		log.Fatalw("pick upstream dns server", "err", err)
 	}
-	log.Infow("detect upstream dns", "addr", relayServer)
+	upstream, err := _dns.NewUpstream(conf.Client.DNS.Strategy, 2*time.Second, addrs...)
+	if err != nil {
+		log.Fatalw("init upstream dns", "err", err)
+	}
+	log.Infow("detect upstream dns", "addr", addrs, "strategy", conf.Client.DNS.Strategy)
+
+	hosts, err := _dns.NewHosts(conf.Client.DNS.Hosts, conf.Client.DNS.HostsFiles...)
+	if err != nil {
+		log.Fatalw("load hosts", "err", err)
+	}
+	if len(conf.Client.DNS.HostsFiles) != 0 {
+		go func() {
+			for range time.Tick(10 * time.Second) {
+				if err := hosts.Reload(); err != nil {
+					log.Errorw("reload hosts", "files", conf.Client.DNS.HostsFiles, "err", err)
+				}
+			}
+		}()
+	}
+
+	var fakeIP *_dns.FakeIP
+	if fake := conf.Client.DNS.FakeIP; fake.CIDR != "" {
+		if fakeIP, err = _dns.NewFakeIP(fake.CIDR); err != nil {
+			log.Fatalw("init fake ip", "err", err)
+		}
+		if fake.Listen == "" {
+			log.Fatalw("init fake ip", "err", "listen address required")
+		}
+		// load before serving, never hand out the ips persisted for other domains
+		if fake.File != "" {
+			if err := fakeIP.Load(fake.File); err != nil {
+				log.Errorw("load fake ip", "file", fake.File, "err", err)
+			}
+		}
+		go startFakeIP(fake.Listen, fakeIP, fake.File)
+	}
+
+	forward := _dns.Forward{}
+	for _, fwd := range conf.Client.DNS.Forward {
+		if err := forward.Add(fwd.Domains, fwd.Strategy, 2*time.Second, _dns.ParseAddrs(fwd.Upstream)...); err != nil {
+			log.Fatalw("init dns forward", "domains", fwd.Domains, "err", err)
+		}
+	}
+
+	// dial lazily, also used by the dns poisoning detector
+	tunnel := newDNSTunnel(conf.Client.Address, []byte(conf.Password), conf.Client.DNS.RemoteUpstream)
+	exchange := func(r *dns.Msg, remote bool) (msg *dns.Msg, addr string, err error) {
+		if remote {
+			msg, err = tunnel.Exchange(r)
+			return msg, "remote", err
+		}
+		if fwd := forward.Match(r.Question[0].Name); fwd != nil {
+			return fwd.Exchange(r)
+		}
+		return upstream.Exchange(r)
+	}
+
+	var cache *_dns.Cache
+	if conf.Client.DNS.CacheSize > 0 {
+		var prefetch func(r *dns.Msg, remote bool)
+		if conf.Client.DNS.Prefetch {
+			// refresh in the way the entry was resolved, the client asked is unknown here
+			prefetch = func(r *dns.Msg, remote bool) {
+				if msg, _, err := exchange(r, remote); err == nil {
+					cache.Set(msg, remote)
+				}
+			}
+		}
+
+		cache = _dns.NewCache(conf.Client.DNS.CacheSize, prefetch)
+		expvar.Publish("dns_cache", expvar.Func(func() interface{} {
+			return map[string]interface{}{"size": cache.Len(), "hits": cache.Hits(), "misses": cache.Misses()}
+		}))
+		conf.SetDNSPurge(cache.Purge)
+	}
+
+	conf.SetDNSExchange(func(r *dns.Msg) (*dns.Msg, error) {
+		msg, _, err := exchange(r, false)
+		return msg, err
+	}, tunnel.Exchange)
+	conf.SetResolver(func(domain string) ([]net.IP, error) {
+		// the answers resolved already first, eg: AAAA while A is not cached
+		qtypes := []uint16{dns.TypeA, dns.TypeAAAA}
+		cached := make([]*dns.Msg, len(qtypes))
+		for i, qtype := range qtypes {
+			if cached[i] = cache.Get(new(dns.Msg).SetQuestion(dns.Fqdn(domain), qtype), false); cached[i] != nil {
+				if ips := _dns.AnswerIPs(cached[i]); len(ips) != 0 {
+					return ips, nil
+				}
+			}
+		}
+
+		for i, qtype := range qtypes {
+			if cached[i] != nil {
+				continue
+			}
+			msg, _, err := exchange(new(dns.Msg).SetQuestion(dns.Fqdn(domain), qtype), false)
+			if err != nil {
+				return nil, err
+			}
+			cache.Set(msg, false)
+			if ips := _dns.AnswerIPs(msg); len(ips) != 0 {
+				return ips, nil
+			}
+		}
+		return nil, nil
+	})
 
+	queryLog, err := _dns.NewQueryLog(conf.Client.DNS.QueryLog.Size, conf.Client.DNS.QueryLog.File,
+		conf.Client.DNS.QueryLog.MaxSize<<20, conf.Client.DNS.QueryLog.Backups)
+	if err != nil {
+		log.Fatalw("open dns query log", "err", err)
+	}
+	expvar.Publish("dns_query", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"recent":      queryLog.Recent(100),
+			"top_domains": queryLog.TopDomains(20),
+			"top_clients": queryLog.TopClients(20),
+		}
+	}))
+
+	redetecting := new(int32)
 	dns.HandleFunc(".", func(w dns.ResponseWriter, r *dns.Msg) {
+		start := time.Now()
 		// *Msg r has an TSIG record and it was validated
 		if r.IsTsig() != nil && w.TsigStatus() == nil {
 			lastTsig := r.Extra[len(r.Extra)-1].(*dns.TSIG)
@@ -40,54 +178,132 @@ func StartDNS(redirectIP, relayServer string) {
 			domain = domain[:idx] // trim port
 		}
 
-		if conf.ShouldProxy(domain) {
-			w.WriteMsg(localA(r, domain, serveIP))
+		client, _, _ := net.SplitHostPort(w.RemoteAddr().String())
+		reply := func(msg *dns.Msg, decision, upstream string) {
+			w.WriteMsg(msg)
+			if err := queryLog.Add(_dns.NewQuery(client, r, msg, decision, upstream, start)); err != nil {
+				log.Errorw("write dns query log", "err", err)
+			}
+		}
 
-		} else if msg, err := dns.Exchange(r, relayServer); err != nil || msg == nil {
-			server, err := pickRelayAddr(relayServer)
-			if err != nil {
-				log.Errorw("detect upstream dns", "err", err)
-			} else if relayServer != server {
-				relayServer = server
-				log.Infow("detect upstream dns", "addr", relayServer)
+		if msg := hosts.Lookup(r, func(r *dns.Msg) (*dns.Msg, error) {
+			msg, _, err := exchange(r, false)
+			return msg, err
+		}); msg != nil {
+			reply(msg, _dns.Local, "")
+			return
+		}
+
+		outbound := conf.Route(conf.InboundDNS, net.ParseIP(client), domain, 0)
+		if outbound == conf.OutboundBlock {
+			blocked.Add("dns", 1)
+			reply(_dns.Block(r, conf.Client.DNS.BlockMode == "zero"), _dns.Blocked, "")
+			return
+		}
+
+		// split dns, never hijack the forwarded domains
+		forwarded := forward.Match(domain) != nil
+		proxy := !forwarded && outbound != conf.OutboundDirect
+		remote := proxy && conf.Client.DNS.RemoteResolve
+		decision := _dns.Direct
+		if proxy {
+			decision = _dns.Proxy
+		}
+		if proxy && !remote {
+			ipv4, ipv6, ttl := serveIP, serveIP6, uint32(20)
+			if fakeIP != nil {
+				ipv4, ipv6, ttl = fakeIP.IP(domain), nil, 10
+			}
+
+			if msg := _dns.Hijack(r, ipv4, ipv6, ttl); msg != nil {
+				reply(msg, decision, "")
+				return
+			}
+		}
+
+		if msg := cache.Get(r, remote); msg != nil {
+			reply(msg, _dns.Cached, "")
+
+		} else if msg, addr, err := exchange(r, remote); err != nil {
+			reply(new(dns.Msg).SetRcode(r, dns.RcodeServerFailure), decision, addr)
+			log.Errorw("relay dns", "domain", domain, "remote", remote, "err", err)
+
+			// upstream servers were detected, the network may have changed
+			if !remote && !forwarded && relayServers == "" && atomic.CompareAndSwapInt32(redetecting, 0, 1) {
+				go func() {
+					defer atomic.StoreInt32(redetecting, 0)
+					if addrs, err := pickRelayAddrs(relayServers, redirectIP, redirectIP6); err != nil {
+						log.Errorw("detect upstream dns", "err", err)
+					} else if upstream.Reset(addrs...) == nil {
+						log.Infow("detect upstream dns", "addr", addrs)
+					}
+				}()
 			}
 
 		} else {
-			w.WriteMsg(msg)
+			cache.Set(msg, remote)
+			reply(msg, decision, addr)
+
+			if !proxy && !forwarded && conf.Client.DNS.DetectPoison {
+				go func(r, msg *dns.Msg) {
+					if remote, err := tunnel.Exchange(r); err != nil {
+						log.Errorw("remote resolve", "domain", domain, "err", err)
+					} else if _dns.Poisoned(msg, remote) {
+						log.Infow("dns answer mismatch, may be poisoned", "domain", domain, "local", msg.Answer, "remote", remote.Answer)
+					}
+				}(r.Copy(), msg)
+			}
 		}
 	})
 
-	server := &dns.Server{Addr: net.JoinHostPort(redirectIP, "53"), Net: "udp"}
-	log.Infow("start dns", "addr", server.Addr)
-	log.Fatalw("dns serve fail", "err", server.ListenAndServe())
+	for _, ip := range []string{redirectIP, redirectIP6} {
+		if ip == "" {
+			continue
+		}
+
+		server := &dns.Server{Addr: net.JoinHostPort(ip, "53"), Net: "udp"}
+		go func() {
+			log.Infow("start dns", "addr", server.Addr)
+			log.Fatalw("dns serve fail", "err", server.ListenAndServe())
+		}()
+	}
+	select {}
 }
 
-func pickRelayAddr(relayServer string) (_ string, err error) {
-	if relayServer == "" {
-		if relayServer, err = _net.GetDefaultDNSServer(); err != nil {
-			return "", err
-		}
+// pickRelayAddrs use the configured servers, or discover the system ones except sower itself
+func pickRelayAddrs(relayServers string, serveIPs ...string) ([]string, error) {
+	if addrs := _dns.ParseAddrs(relayServers); len(addrs) != 0 {
+		return addrs, nil
+	}
+
+	return _net.GetDefaultDNSServers(serveIPs...)
+}
+
+// defaultRemoteDNS is used though socks5 proxy if remote_upstream is empty,
+// socks5 proxy could only relay the dns over tcp to a specified server
+const defaultRemoteDNS = "8.8.8.8:53"
+
+// newDNSTunnel resolve though the sower server, with the resolver of the server if upstream is empty
+func newDNSTunnel(serverAddr string, password []byte, upstream string) *_dns.Tunnel {
+	if _, ok := socks5.IsSocks5Schema(serverAddr); ok && upstream == "" {
+		upstream = defaultRemoteDNS
+		log.Infow("resolve remotely though socks5 with default dns, set remote_upstream to change", "upstream", upstream)
 	}
 
-	if _, _, err := net.SplitHostPort(relayServer); err != nil {
-		return net.JoinHostPort(relayServer, "53"), nil
+	host, port := "", uint16(0)
+	if upstream != "" {
+		host, port = util.ParseHostPort(upstream, 53)
 	}
-	return relayServer, nil
+
+	return _dns.NewTunnel(3*time.Second, func() (net.Conn, error) {
+		return dial(serverAddr, password, _http.TGT_DNS, host, port)
+	})
 }
 
-func localA(r *dns.Msg, domain string, localIP net.IP) *dns.Msg {
-	m := new(dns.Msg)
-	m.SetReply(r)
-	if localIP.To4() != nil {
-		m.Answer = []dns.RR{&dns.A{
-			Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 20},
-			A:   localIP,
-		}}
-	} else {
-		m.Answer = []dns.RR{&dns.AAAA{
-			Hdr:  dns.RR_Header{Name: domain, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 20},
-			AAAA: localIP,
-		}}
+// systemResolver pick the first nameserver in /etc/resolv.conf for remote resolving
+func systemResolver() string {
+	if cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf"); err == nil && len(cfg.Servers) != 0 {
+		return net.JoinHostPort(cfg.Servers[0], cfg.Port)
 	}
-	return m
+	return defaultRemoteDNS
 }
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net"
+	"time"
+
+	"github.com/wweir/sower/conf"
+	_dns "github.com/wweir/sower/internal/dns"
+	_http "github.com/wweir/sower/internal/http"
+	"github.com/wweir/utils/log"
+)
+
+// startFakeIP relay the connections redirected from the fake ip range,
+// route them by the domain the destination ip allocated for, eg:
+// iptables -t nat -A OUTPUT -p tcp -d 198.18.0.0/15 -j REDIRECT --to-ports 1081
+func startFakeIP(lnAddr string, pool *_dns.FakeIP, file string) {
+	if file != "" {
+		go func() {
+			for range time.Tick(10 * time.Second) {
+				if err := pool.Save(file); err != nil {
+					log.Errorw("save fake ip", "file", file, "err", err)
+				}
+			}
+		}()
+	}
+
+	ln, err := listen(lnAddr)
+	if err != nil {
+		log.Fatalw("tcp listen", "port", lnAddr, "err", err)
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Errorw("tcp accept", "port", lnAddr, "err", err)
+			continue
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			ip, port, err := originalDst(conn)
+			if err != nil {
+				log.Errorw("get original destination", "err", err)
+				return
+			}
+			domain, ok := pool.Domain(ip)
+			if !ok {
+				log.Errorw("fake ip not allocated", "ip", ip)
+				return
+			}
+
+			outbound := conf.Route(conf.InboundFakeIP, clientIP(conn.RemoteAddr().String()), domain, port)
+			if outbound == conf.OutboundBlock {
+				blocked.Add(conf.InboundFakeIP, 1)
+				return
+			}
+
+			rc, err := dialOutbound(outbound, _http.TGT_OTHER, domain, port)
+			if err != nil {
+				log.Errorw("dial", "domain", domain, "port", port, "err", err)
+				return
+			}
+			defer rc.Close()
+
+			relay(conn, rc)
+		}(conn)
+	}
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wweir/sower/conf"
+	_http "github.com/wweir/sower/internal/http"
+)
+
+// interfered check if err looks like the interference of firewall: reset, timeout or cut in the middle.
+// A plain close by server is not counted.
+func interfered(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || strings.Contains(err.Error(), "connection reset")
+}
+
+// replayable check if the request could be sent again safely
+func replayable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return r.ContentLength == 0 && len(r.TransferEncoding) == 0
+	default:
+		return false
+	}
+}
+
+// connectDirect dial the target directly for the CONNECT request. On port 443, the TLS
+// handshake is exchanged first to find the interference, and retried though proxy if enabled.
+func connectDirect(conn net.Conn, host string, port uint16) (net.Conn, error) {
+	rc, err := dialDirect(host, port)
+	if err != nil {
+		return retryProxy(host, port, nil, err)
+	}
+	if port != 443 {
+		return rc, nil // the server may speak first
+	}
+
+	// the client speaks first in TLS, the server answers after the whole ClientHello received
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	hello, isTLS, err := readClientHello(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	if !isTLS {
+		if _, err := rc.Write(hello); err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return rc, nil
+	}
+
+	buf := make([]byte, 16*1024)
+	if _, err = rc.Write(hello); err == nil {
+		rc.SetReadDeadline(time.Now().Add(10 * time.Second))
+		var n int
+		if n, err = rc.Read(buf); err == nil {
+			rc.SetReadDeadline(time.Time{})
+			conf.ReportDirect(host, false)
+
+			if _, err = conn.Write(buf[:n]); err != nil {
+				rc.Close()
+				return nil, err
+			}
+			return rc, nil
+		}
+	}
+	rc.Close()
+	return retryProxy(host, port, hello, err)
+}
+
+// readClientHello read the TLS records until the ClientHello completes, it may span
+// TCP segments and records, eg: with large post-quantum key shares.
+// The bytes read are returned as is, isTLS is false if not started with a handshake record.
+func readClientHello(conn net.Conn) (data []byte, isTLS bool, err error) {
+	const maxHello = 64 * 1024
+
+	msgLen := -1 // the length of the handshake message with header, unknown yet
+	hs := []byte{}
+	for msgLen < 0 || len(hs) < msgLen {
+		header := make([]byte, 5)
+		n, err := io.ReadFull(conn, header)
+		data = append(data, header[:n]...)
+		if err != nil {
+			return data, isTLS, err
+		}
+		if header[0] != 0x16 { // handshake
+			return data, isTLS, nil
+		}
+		isTLS = true
+
+		length := int(header[3])<<8 | int(header[4])
+		if len(data)+length > maxHello {
+			return data, isTLS, errors.New("client hello too large")
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return data, isTLS, err
+		}
+		data, hs = append(data, body...), append(hs, body...)
+
+		if msgLen < 0 && len(hs) >= 4 {
+			msgLen = 4 + (int(hs[1])<<16 | int(hs[2])<<8 | int(hs[3]))
+		}
+	}
+	return data, isTLS, nil
+}
+
+// retryProxy report the direct failure, and dial though proxy with the data sent if fail_retry enabled
+func retryProxy(host string, port uint16, sent []byte, err error) (net.Conn, error) {
+	if !interfered(err) {
+		return nil, err
+	}
+	conf.ReportDirect(host, true)
+	if !conf.Client.Router.FailRetry {
+		return nil, err
+	}
+
+	rc, err := dialOutbound(conf.OutboundProxy, _http.TGT_OTHER, host, port)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rc.Write(sent); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return rc, nil
+}
@@ -6,7 +6,6 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/wweir/sower/conf"
@@ -15,14 +14,17 @@ import (
 	"github.com/wweir/utils/log"
 )
 
-func startHTTPProxy(httpProxyAddr, serverAddr string, password []byte) {
+func startHTTPProxy(httpProxyAddr string) {
 	srv := &http.Server{
 		Addr: httpProxyAddr,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.Method == http.MethodConnect {
-				httpsProxy(w, r, serverAddr, password)
+			if r.Method != http.MethodConnect && !r.URL.IsAbs() {
+				// not a proxy request, the local status is served by StartStatus
+				http.Error(w, "not a proxy request", http.StatusBadRequest)
+			} else if r.Method == http.MethodConnect {
+				httpsProxy(w, r)
 			} else {
-				httpProxy(w, r, serverAddr, password)
+				httpProxy(w, r)
 			}
 		}),
 		// Disable HTTP/2.
@@ -30,20 +32,44 @@ func startHTTPProxy(httpProxyAddr, serverAddr string, password []byte) {
 		IdleTimeout:  90 * time.Second,
 	}
 
-	go log.Fatalw("serve http proxy", "addr", httpProxyAddr, "err", srv.ListenAndServe())
+	ln, err := listen(httpProxyAddr)
+	if err != nil {
+		log.Fatalw("serve http proxy", "addr", httpProxyAddr, "err", err)
+	}
+	log.Fatalw("serve http proxy", "addr", httpProxyAddr, "err", srv.Serve(ln))
 }
 
-func httpProxy(w http.ResponseWriter, r *http.Request, serverAddr string, password []byte) {
+func httpProxy(w http.ResponseWriter, r *http.Request) {
 	host, port := util.ParseHostPort(r.Host, 80)
+	outbound := conf.Route(conf.InboundHTTPProxy, clientIP(r.RemoteAddr), host, port)
+	if outbound == conf.OutboundBlock {
+		blocked.Add("http", 1)
+		http.Error(w, "blocked by sower", http.StatusForbidden)
+		return
+	}
 
-	roundTripper := &http.Transport{}
-	if conf.ShouldProxy(host) {
-		roundTripper.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return dial(serverAddr, password, _http.TGT_HTTP, host, port)
-		}
+	roundTripper := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialOutbound(outbound, _http.TGT_HTTP, host, port)
+		},
 	}
 
 	resp, err := roundTripper.RoundTrip(r)
+	if outbound == conf.OutboundDirect {
+		switch {
+		case err == nil:
+			conf.ReportDirect(host, false)
+		case interfered(err):
+			conf.ReportDirect(host, true)
+			if conf.Client.Router.FailRetry && replayable(r) {
+				resp, err = (&http.Transport{
+					DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+						return dialOutbound(conf.OutboundProxy, _http.TGT_HTTP, host, port)
+					},
+				}).RoundTrip(r)
+			}
+		}
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
@@ -59,8 +85,14 @@ func httpProxy(w http.ResponseWriter, r *http.Request, serverAddr string, passwo
 	io.Copy(w, resp.Body)
 }
 
-func httpsProxy(w http.ResponseWriter, r *http.Request, serverAddr string, password []byte) {
+func httpsProxy(w http.ResponseWriter, r *http.Request) {
 	host, port := util.ParseHostPort(r.Host, 443)
+	outbound := conf.Route(conf.InboundHTTPProxy, clientIP(r.RemoteAddr), host, port)
+	if outbound == conf.OutboundBlock {
+		blocked.Add("https", 1)
+		http.Error(w, "blocked by sower", http.StatusForbidden)
+		return
+	}
 
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
@@ -76,13 +108,13 @@ func httpsProxy(w http.ResponseWriter, r *http.Request, serverAddr string, passw
 	}
 
 	var rc net.Conn
-	if conf.ShouldProxy(host) {
-		rc, err = dial(serverAddr, password, _http.TGT_HTTPS, host, port)
+	if outbound == conf.OutboundDirect {
+		rc, err = connectDirect(conn, host, port)
 	} else {
-		rc, err = net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		rc, err = dialOutbound(outbound, _http.TGT_HTTPS, host, port)
 	}
 	if err != nil {
-		conn.Write([]byte("sower dial " + serverAddr + " fail: " + err.Error()))
+		conn.Write([]byte("sower dial " + outbound + " fail: " + err.Error()))
 		conn.Close()
 		return
 	}
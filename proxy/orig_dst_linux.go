@@ -0,0 +1,37 @@
+// +build linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+const soOriginalDst = 80 // from linux/netfilter_ipv4.h
+
+// originalDst get the destination before iptables REDIRECT
+func originalDst(conn net.Conn) (net.IP, uint16, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, 0, errors.New("not a tcp connection")
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// struct sockaddr_in is fetched by the same size struct ipv6_mreq
+	var addr *syscall.IPv6Mreq
+	if err := raw.Control(func(fd uintptr) {
+		addr, err = syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+	}); err != nil {
+		return nil, 0, err
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sa := addr.Multiaddr // family(2) + port(2) + addr(4)
+	return net.IPv4(sa[4], sa[5], sa[6], sa[7]), uint16(sa[2])<<8 | uint16(sa[3]), nil
+}
@@ -0,0 +1,18 @@
+// +build !linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+)
+
+// originalDst take the local address as destination,
+// the port is only right when connections are not redirected from other ports
+func originalDst(conn net.Conn) (net.IP, uint16, error) {
+	addr, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, 0, errors.New("not a tcp connection")
+	}
+	return addr.IP, uint16(addr.Port), nil
+}
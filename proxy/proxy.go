@@ -2,12 +2,15 @@ package proxy
 
 import (
 	"crypto/tls"
+	"io"
 	"net"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/wweir/sower/conf"
+	_dns "github.com/wweir/sower/internal/dns"
 	_http "github.com/wweir/sower/internal/http"
-	"github.com/wweir/sower/internal/socks5"
 	"github.com/wweir/sower/util"
 	"github.com/wweir/utils/log"
 	"golang.org/x/crypto/acme/autocert"
@@ -20,16 +23,18 @@ type head struct {
 	length   byte
 }
 
-func StartClient(password, serverAddr, httpProxy, dnsServeIP string, forwards map[string]string) {
-	passwordData := []byte(password)
-	_, isSocks5 := socks5.IsSocks5Schema(serverAddr)
+func StartClient(httpProxy string, dnsServeIPs []string, forwards map[string]string) {
+	conf.SetDialer(func(network, addr string) (net.Conn, error) {
+		host, port := util.ParseHostPort(addr, 0)
+		return dialOutbound(conf.OutboundProxy, _http.TGT_OTHER, host, port)
+	})
 
 	if httpProxy != "" {
-		go startHTTPProxy(httpProxy, serverAddr, passwordData)
+		go startHTTPProxy(httpProxy)
 	}
 
-	relayToRemote := func(tgtType byte, lnAddr string, host string, port uint16) {
-		ln, err := net.Listen("tcp", lnAddr)
+	relayToRemote := func(tgtType byte, inbound, lnAddr string, host string, port uint16) {
+		ln, err := listen(lnAddr)
 		if err != nil {
 			log.Fatalw("tcp listen", "port", lnAddr, "err", err)
 		}
@@ -41,13 +46,15 @@ func StartClient(password, serverAddr, httpProxy, dnsServeIP string, forwards ma
 				continue
 			}
 
-			go func(conn net.Conn) {
+			go func(conn net.Conn, host string, port uint16) {
 				defer conn.Close()
+				client := clientIP(conn.RemoteAddr().String())
 
-				if isSocks5 {
+				if tgtType != _http.TGT_OTHER {
 					teeConn := &util.TeeConn{Conn: conn}
 					teeConn.StartOrReset()
 
+					var err error
 					switch tgtType {
 					case _http.TGT_HTTP:
 						conn, host, port, err = _http.ParseHTTP(teeConn)
@@ -55,33 +62,41 @@ func StartClient(password, serverAddr, httpProxy, dnsServeIP string, forwards ma
 						conn, host, err = _http.ParseHTTPS(teeConn)
 					}
 					if err != nil {
-						log.Errorw("parse socks5 target", "err", err)
+						log.Errorw("parse target", "err", err)
 						return
 					}
 					teeConn.Stop()
 				}
 
-				rc, err := dial(serverAddr, passwordData, tgtType, host, port)
+				outbound := conf.Route(inbound, client, host, port)
+				if outbound == conf.OutboundBlock {
+					blocked.Add(inbound, 1)
+					return
+				}
+
+				rc, err := dialOutbound(outbound, tgtType, host, port)
 				if err != nil {
-					log.Errorw("dial", "addr", serverAddr, "err", err)
+					log.Errorw("dial", "outbound", outbound, "host", host, "err", err)
 					return
 				}
 				defer rc.Close()
 
 				relay(conn, rc)
-			}(conn)
+			}(conn, host, port)
 		}
 	}
 
-	if dnsServeIP != "" {
-		go relayToRemote(_http.TGT_HTTP, dnsServeIP+":http", "", 80)
-		go relayToRemote(_http.TGT_HTTPS, dnsServeIP+":https", "", 443)
+	for _, dnsServeIP := range dnsServeIPs {
+		if dnsServeIP != "" {
+			go relayToRemote(_http.TGT_HTTP, conf.InboundDNS, net.JoinHostPort(dnsServeIP, "http"), "", 80)
+			go relayToRemote(_http.TGT_HTTPS, conf.InboundDNS, net.JoinHostPort(dnsServeIP, "https"), "", 443)
+		}
 	}
 
 	for from, to := range forwards {
 		go func(from, to string) {
 			host, port := util.ParseHostPort(to, 0)
-			relayToRemote(_http.TGT_OTHER, from, host, port)
+			relayToRemote(_http.TGT_OTHER, conf.InboundPortMapping, from, host, port)
 		}(from, to)
 	}
 
@@ -124,6 +139,7 @@ func StartServer(relayTarget, password, certFile, keyFile, email string) {
 		log.Fatalw("tcp listen", "err", err)
 	}
 
+	resolver := systemResolver()
 	passwordData := []byte(password)
 	for {
 		conn, err := ln.Accept()
@@ -133,13 +149,24 @@ func StartServer(relayTarget, password, certFile, keyFile, email string) {
 		}
 
 		go func(conn net.Conn) {
-			conn, domain, port, err := _http.ParseAddr(conn, passwordData)
+			conn, tgtType, domain, port, err := _http.ParseTarget(conn, passwordData)
 			if err != nil {
 				log.Errorw("parse relay target", "err", err)
 				return
 			}
 			defer conn.Close()
 
+			if tgtType == _http.TGT_DNS {
+				addr := resolver
+				if domain != "" {
+					addr = net.JoinHostPort(domain, strconv.Itoa(int(port)))
+				}
+				if err := _dns.ServeTunnel(conn, addr, time.Minute); err != nil && err != io.EOF {
+					log.Errorw("serve remote dns", "addr", addr, "err", err)
+				}
+				return
+			}
+
 			addr := relayTarget
 			if domain != "" {
 				addr = net.JoinHostPort(domain, strconv.Itoa(int(port)))
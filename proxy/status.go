@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"expvar"
+	"io"
+	"net/http"
+
+	"github.com/wweir/sower/conf"
+	"github.com/wweir/utils/log"
+)
+
+// statusMux serve the local stats and controls, never exposed on the proxy port
+var statusMux = http.NewServeMux()
+
+func init() {
+	statusMux.Handle("/debug/vars", expvar.Handler())
+	statusMux.HandleFunc("/rules", editRules)
+}
+
+// StartStatus serve statusMux for the loopback clients only, eg: curl 127.0.0.1:8081/debug/vars
+func StartStatus(addr string) {
+	srv := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := clientIP(r.RemoteAddr); ip == nil || !ip.IsLoopback() {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			statusMux.ServeHTTP(w, r)
+		}),
+	}
+
+	ln, err := listen(addr)
+	if err != nil {
+		log.Fatalw("serve status", "addr", addr, "err", err)
+	}
+	log.Fatalw("serve status", "addr", addr, "err", srv.Serve(ln))
+}
+
+// editRules move a rule between lists at runtime, eg:
+// curl -X POST '127.0.0.1:8081/rules?rule=**.example.com&from=dynamic&to=proxy'
+func editRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := conf.EditRule(r.FormValue("rule"), r.FormValue("from"), r.FormValue("to")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	io.WriteString(w, "ok\n")
+}
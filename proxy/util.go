@@ -2,16 +2,25 @@ package proxy
 
 import (
 	"crypto/tls"
+	"errors"
+	"expvar"
 	"io"
 	"net"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/wweir/sower/conf"
 	"github.com/wweir/sower/internal/http"
 	"github.com/wweir/sower/internal/socks5"
 )
 
+// blocked count the blocked requests by dns / http / https / inbound
+var blocked = expvar.NewMap("blocked")
+
+var errSelf = errors.New("refuse to relay to sower itself")
+
 func dial(serverAddr string, password []byte, tgtType byte, domain string, port uint16) (net.Conn, error) {
 	if addr, ok := socks5.IsSocks5Schema(serverAddr); ok {
 		conn, err := net.Dial("tcp", addr)
@@ -25,9 +34,142 @@ func dial(serverAddr string, password []byte, tgtType byte, domain string, port
 	if err != nil {
 		return nil, err
 	}
+	if tgtType == http.TGT_HTTP || tgtType == http.TGT_HTTPS {
+		domain = "" // parsed from data by server
+	}
 	return http.NewTgtConn(conn, password, tgtType, domain, port), nil
 }
 
+// dialOutbound dial the target though the outbound picked by router
+func dialOutbound(outbound string, tgtType byte, host string, port uint16) (net.Conn, error) {
+	switch outbound {
+	case conf.OutboundDirect:
+		return dialDirect(host, port)
+	case conf.OutboundBlock:
+		return nil, errors.New("blocked by sower")
+	}
+
+	addr, password, ok := conf.Outbound(outbound)
+	if !ok {
+		return nil, errors.New("outbound not configured: " + outbound)
+	}
+	return dial(addr, []byte(password), tgtType, host, port)
+}
+
+// directDialer give up an address after the timeout, and try the next one
+var directDialer = &net.Dialer{Timeout: 3 * time.Second}
+
+// dialDirect resolve host bypass the dns relay, which may hijack it to sower itself
+func dialDirect(host string, port uint16) (net.Conn, error) {
+	portStr := strconv.Itoa(int(port))
+	if ip := net.ParseIP(host); ip != nil {
+		if isSelf(ip, port) {
+			return nil, errSelf
+		}
+		return directDialer.Dial("tcp", net.JoinHostPort(host, portStr))
+	}
+
+	ips, err := conf.Resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no address for " + host)
+	}
+
+	for _, ip := range interleave(ips) {
+		if isSelf(ip, port) {
+			return nil, errSelf
+		}
+
+		var conn net.Conn
+		if conn, err = directDialer.Dial("tcp", net.JoinHostPort(ip.String(), portStr)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// interleave alternate the ipv4 and ipv6 addresses, starting with the family of the first one,
+// so a broken family never takes all the attempts
+func interleave(ips []net.IP) []net.IP {
+	first, second := []net.IP{}, []net.IP{}
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (ips[0].To4() != nil) {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+
+	out := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+// listened are the tcp addresses sower listens on
+var listened = struct {
+	sync.RWMutex
+	m map[int][]net.IP // port => the ips bound
+}{m: map[int][]net.IP{}}
+
+// listen tcp on addr, and remember it to never relay to itself
+func listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpAddr, ok := ln.Addr().(*net.TCPAddr); ok {
+		listened.Lock()
+		listened.m[tcpAddr.Port] = append(listened.m[tcpAddr.Port], tcpAddr.IP)
+		listened.Unlock()
+	}
+	return ln, nil
+}
+
+// isSelf check if ip:port is a listener of sower, relaying to it loops,
+// or bypass the loopback only checks, eg: the status listener
+func isSelf(ip net.IP, port uint16) bool {
+	listened.RLock()
+	bounds := listened.m[int(port)]
+	listened.RUnlock()
+
+	for _, bound := range bounds {
+		switch {
+		case bound.Equal(ip), ip.IsUnspecified():
+			return true
+		case bound.IsUnspecified():
+			if ip.IsLoopback() {
+				return true
+			}
+			addrs, _ := net.InterfaceAddrs()
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// clientIP parse the ip of a remote address, nil if unknown
+func clientIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.ParseIP(host)
+}
+
 func relay(conn1, conn2 net.Conn) {
 	wg := &sync.WaitGroup{}
 	exitFlag := new(int32)
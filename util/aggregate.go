@@ -0,0 +1,127 @@
+package util
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Aggregation is a wildcard rule proposed to replace the rules it covers
+type Aggregation struct {
+	Rule    string   // eg: *.cdn.x.com / **.x.com
+	Covered []string // the rules replaced, in ReverseSecSlice order
+}
+
+// Aggregate propose wildcard rules for the domains sharing a parent with at least 2 sections.
+// `**.parent` is proposed if the parent itself is listed, as it matches the parent too,
+// and at least min domains spread under 2 children of the parent at least, and some of them
+// are deeper than children. Otherwise `*.parent` if at least min domains are the children of the parent.
+// Parents containing any literal rule in keep, eg: the direct list, are never aggregated.
+// Neither are the public suffixes, eg: co.uk / com.cn, whose children are owned by different sites.
+func Aggregate(domains []string, min int, keep []string) []Aggregation {
+	if min < 2 {
+		min = 2
+	}
+	sorted := NewReverseSecSlice(append([]string{}, domains...)).Sort().Uniq()
+	listed := make(map[string]bool, len(sorted))
+	for _, domain := range sorted {
+		listed[domain] = true
+	}
+
+	children := map[string]map[string]bool{} // parent => child sections
+	descendants := map[string][]string{}     // parent => domains under it
+	for _, domain := range sorted {
+		if strings.Contains(domain, "*") {
+			continue
+		}
+
+		secs := strings.Split(domain, ".")
+		for i := 1; i < len(secs)-1; i++ {
+			parent := strings.Join(secs[i:], ".")
+			if children[parent] == nil {
+				children[parent] = map[string]bool{}
+			}
+			children[parent][secs[i-1]] = true
+			descendants[parent] = append(descendants[parent], domain)
+		}
+	}
+
+	parents := make([]string, 0, len(children))
+	for parent := range children {
+		parents = append(parents, parent)
+	}
+	sort.Slice(parents, func(i, j int) bool { // the upper parents first
+		ci, cj := strings.Count(parents[i], "."), strings.Count(parents[j], ".")
+		return ci < cj || ci == cj && parents[i] < parents[j]
+	})
+
+	out := []Aggregation{}
+	aggregated := []string{} // the parents of the ** rules proposed
+	for _, parent := range parents {
+		if under(parent, aggregated...) || shadows(parent, keep) || publicSuffix(parent) {
+			continue
+		}
+
+		direct := []string{}
+		for _, domain := range descendants[parent] {
+			if strings.Count(domain, ".") == strings.Count(parent, ".")+1 {
+				direct = append(direct, domain)
+			}
+		}
+
+		deeper := len(descendants[parent]) > len(direct)
+		if listed[parent] && deeper && len(children[parent]) >= 2 && len(descendants[parent]) >= min {
+			covered := []string{}
+			for _, domain := range sorted {
+				if domain != "**."+parent && under(strings.TrimLeft(domain, "*."), parent) {
+					covered = append(covered, domain)
+				}
+			}
+			out = append(out, Aggregation{"**." + parent, covered})
+			aggregated = append(aggregated, parent)
+			continue
+		}
+
+		if len(direct) >= min {
+			out = append(out, Aggregation{"*." + parent, direct})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		rules := ReverseSecSlice{sort.StringSlice{out[i].Rule, out[j].Rule}}
+		return rules.Less(0, 1)
+	})
+	return out
+}
+
+// publicSuffix check if domain is a public suffix, eg: com / co.uk / github.io
+func publicSuffix(domain string) bool {
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	return suffix == domain
+}
+
+// under check if domain is parent or under any of the parents
+func under(domain string, parents ...string) bool {
+	for _, parent := range parents {
+		if domain == parent || strings.HasSuffix(domain, "."+parent) {
+			return true
+		}
+	}
+	return false
+}
+
+// shadows check if a wildcard on parent would cover a literal rule in keep,
+// or the parent is covered by a ** rule in keep already
+func shadows(parent string, keep []string) bool {
+	for _, rule := range keep {
+		base := strings.TrimLeft(rule, "*.")
+		if strings.Contains(base, "*") {
+			continue // generic rules like imap.*.*, still win at runtime
+		}
+		if under(base, parent) || strings.HasPrefix(rule, "**.") && under(parent, base) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	domains := []string{
+		"a.cdn.x.com", "b.cdn.x.com", "c.cdn.x.com",
+		"y.com", "www.y.com", "img.y.com", "a.static.y.com", "*.media.y.com",
+		"a.z.net", "b.z.net", "c.z.net",
+		"a.k.org", "b.k.org",
+		"one.com", "two.com", "three.com",
+	}
+	direct := []string{"imap.*.*", "**.cn", "pay.z.net"}
+
+	got := Aggregate(domains, 3, direct)
+	want := []Aggregation{
+		{"*.cdn.x.com", []string{"a.cdn.x.com", "b.cdn.x.com", "c.cdn.x.com"}},
+		{"**.y.com", []string{"y.com", "img.y.com", "*.media.y.com", "a.static.y.com", "www.y.com"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate() = %v, want %v", got, want)
+	}
+
+	// z.net is shadowed by the direct rule pay.z.net
+	for _, agg := range Aggregate(domains, 2, direct) {
+		if agg.Rule == "*.z.net" || agg.Rule == "**.z.net" {
+			t.Errorf("Aggregate() proposed %s shadowing direct rule pay.z.net", agg.Rule)
+		}
+	}
+}
+
+func TestAggregateUnlistedParent(t *testing.T) {
+	// **.y.com matches y.com too, which is never learned
+	got := Aggregate([]string{"www.y.com", "a.static.y.com", "b.static.y.com"}, 2, nil)
+	want := []Aggregation{
+		{"*.static.y.com", []string{"a.static.y.com", "b.static.y.com"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate() = %v, want %v", got, want)
+	}
+}
+
+func TestAggregateUnderDirectWildcard(t *testing.T) {
+	got := Aggregate([]string{"a.b.cn", "c.b.cn", "d.b.cn"}, 2, []string{"**.cn"})
+	if len(got) != 0 {
+		t.Errorf("Aggregate() = %v, want none under direct **.cn", got)
+	}
+}
+
+func TestAggregatePublicSuffix(t *testing.T) {
+	domains := []string{
+		"bbc.co.uk", "x.co.uk", "a.y.co.uk",
+		"a.sina.com.cn", "b.sina.com.cn", "c.sina.com.cn", "qq.com.cn", "a.baidu.com.cn",
+	}
+
+	got := Aggregate(domains, 2, nil)
+	want := []Aggregation{
+		{"*.sina.com.cn", []string{"a.sina.com.cn", "b.sina.com.cn", "c.sina.com.cn"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"net"
+)
+
+// IPNode is a binary prefix tree of cidrs, ips are matched by the longest prefix.
+// IPv4 cidrs are stored as IPv4-mapped IPv6 ones.
+type IPNode struct {
+	ipNode
+}
+type ipNode struct {
+	sub [2]*ipNode
+	val interface{}
+	set bool
+}
+
+// NewIPNodeFromCIDRs create a tree with all cidrs mapped to val
+func NewIPNodeFromCIDRs(val interface{}, cidrs ...string) (*IPNode, error) {
+	n := &IPNode{}
+	for i := range cidrs {
+		if err := n.Add(cidrs[i], val); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// Add map cidr to val, a single ip is taken as a host cidr
+func (n *IPNode) Add(cidr string, val interface{}) error {
+	ipNet, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	ip := ipNet.IP.To16()
+	ones, bits := ipNet.Mask.Size()
+	ones += 128 - bits
+
+	cur := &n.ipNode
+	for i := 0; i < ones; i++ {
+		bit := ip[i/8] >> uint(7-i%8) & 1
+		if cur.sub[bit] == nil {
+			cur.sub[bit] = &ipNode{}
+		}
+		cur = cur.sub[bit]
+	}
+	cur.val, cur.set = val, true
+	return nil
+}
+
+func parseCIDR(cidr string) (*net.IPNet, error) {
+	if ip := net.ParseIP(cidr); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	return ipNet, err
+}
+
+// Lookup return the value of the longest cidr contains ip
+func (n *IPNode) Lookup(ip net.IP) (val interface{}, ok bool) {
+	if n == nil {
+		return nil, false
+	}
+	if ip = ip.To16(); ip == nil {
+		return nil, false
+	}
+
+	cur := &n.ipNode
+	for i := 0; cur != nil; i++ {
+		if cur.set {
+			val, ok = cur.val, true
+		}
+		if i == 8*net.IPv6len {
+			break
+		}
+		cur = cur.sub[ip[i/8]>>uint(7-i%8)&1]
+	}
+	return val, ok
+}
+
+// Match check if any cidr contains ip
+func (n *IPNode) Match(ip net.IP) bool {
+	_, ok := n.Lookup(ip)
+	return ok
+}
@@ -0,0 +1,41 @@
+package util
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPNode_Lookup(t *testing.T) {
+	n, err := NewIPNodeFromCIDRs(false, "10.0.0.0/8", "192.168.1.1", "fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Add("10.1.0.0/16", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Add("10.1.0.0/33", true); err == nil {
+		t.Error("Add() invalid cidr without error")
+	}
+
+	tests := []struct {
+		ip      string
+		want    interface{}
+		matched bool
+	}{
+		{"10.2.3.4", false, true},
+		{"10.1.3.4", true, true},
+		{"192.168.1.1", false, true},
+		{"192.168.1.2", nil, false},
+		{"fd12::1", false, true},
+		{"fe80::1", nil, false},
+		{"::ffff:10.1.0.1", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got, ok := n.Lookup(net.ParseIP(tt.ip))
+			if got != tt.want || ok != tt.matched {
+				t.Errorf("Lookup() = %v, %v, want %v, %v", got, ok, tt.want, tt.matched)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package util
+
+// KeywordMatcher match the strings containing any keyword, case insensitive.
+// It is an Aho-Corasick automaton compiled into a dense transition table.
+type KeywordMatcher struct {
+	class   [256]uint16 // byte => alphabet index, 0 for bytes out of keywords
+	width   int         // alphabet size
+	next    []int32     // state*width+class => state
+	outputs [][]int32   // state => keyword ids ends here, including the suffix states
+}
+
+// NewKeywordMatcher compile the keywords, the empty ones are ignored
+func NewKeywordMatcher(keywords ...string) *KeywordMatcher {
+	m := &KeywordMatcher{width: 1}
+	for _, kw := range keywords {
+		for i := 0; i < len(kw); i++ {
+			c := lower(kw[i])
+			if m.class[c] == 0 {
+				m.class[c] = uint16(m.width)
+				m.width++
+			}
+		}
+	}
+	for c := 'A'; c <= 'Z'; c++ {
+		m.class[c] = m.class[c+'a'-'A']
+	}
+
+	// trie
+	goTo := [][]int32{make([]int32, m.width)}
+	m.outputs = [][]int32{nil}
+	for id, kw := range keywords {
+		if kw == "" {
+			continue
+		}
+
+		state := int32(0)
+		for i := 0; i < len(kw); i++ {
+			c := m.class[kw[i]]
+			if goTo[state][c] == 0 {
+				goTo = append(goTo, make([]int32, m.width))
+				m.outputs = append(m.outputs, nil)
+				goTo[state][c] = int32(len(goTo) - 1)
+			}
+			state = goTo[state][c]
+		}
+		m.outputs[state] = append(m.outputs[state], int32(id))
+	}
+
+	// fail links by BFS, turn the trie into DFA
+	fail := make([]int32, len(goTo))
+	queue := []int32{}
+	for c := 1; c < m.width; c++ {
+		if s := goTo[0][c]; s != 0 {
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) != 0 {
+		state := queue[0]
+		queue = queue[1:]
+		m.outputs[state] = append(m.outputs[state], m.outputs[fail[state]]...)
+
+		for c := 1; c < m.width; c++ {
+			if s := goTo[state][c]; s != 0 {
+				fail[s] = goTo[fail[state]][c]
+				queue = append(queue, s)
+			} else {
+				goTo[state][c] = goTo[fail[state]][c]
+			}
+		}
+	}
+
+	m.next = make([]int32, 0, len(goTo)*m.width)
+	for _, row := range goTo {
+		m.next = append(m.next, row...)
+	}
+	return m
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// Match check if s contains any keyword
+func (m *KeywordMatcher) Match(s string) bool {
+	return m.MatchID(s, nil)
+}
+
+// MatchID check if s contains any keyword, fn is called with the ids of matched keywords
+// until it returns true
+func (m *KeywordMatcher) MatchID(s string, fn func(id int) bool) bool {
+	if m == nil || len(m.outputs) == 1 {
+		return false
+	}
+
+	state := int32(0)
+	for i := 0; i < len(s); i++ {
+		state = m.next[int(state)*m.width+int(m.class[s[i]])]
+		for _, id := range m.outputs[state] {
+			if fn == nil || fn(int(id)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package util
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestKeywordMatcher_Match(t *testing.T) {
+	m := NewKeywordMatcher("googlevideo", "ads", "he", "she", "hers", "")
+	tests := []struct {
+		arg  string
+		want bool
+	}{
+		{"r1---sn-a5mekn7s.googlevideo.com", true},
+		{"R1.GoogleVideo.COM", true},
+		{"pagead.example.com", false},
+		{"ads.example.com", true},
+		{"ushers.com", true},
+		{"sh.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.arg); got != tt.want {
+			t.Errorf("KeywordMatcher.Match(%s) = %v, want %v", tt.arg, got, tt.want)
+		}
+	}
+
+	if NewKeywordMatcher().Match("anything") {
+		t.Error("empty KeywordMatcher matched")
+	}
+}
+
+func TestKeywordMatcher_AllBytes(t *testing.T) {
+	keywords := make([]string, 0, 256)
+	for c := 0; c < 256; c++ {
+		keywords = append(keywords, string([]byte{byte(c), byte(c)}))
+	}
+	m := NewKeywordMatcher(keywords...)
+
+	for _, s := range []string{"\x01\x01", "\xfe\xfe", "a.\xff\xff"} {
+		if !m.Match(s) {
+			t.Errorf("KeywordMatcher.Match(%q) = false, want true", s)
+		}
+	}
+	for _, s := range []string{"\x01\x02", "\xfe\xff", "a.b"} {
+		if m.Match(s) {
+			t.Errorf("KeywordMatcher.Match(%q) = true, want false", s)
+		}
+	}
+}
+
+func TestRegexMatcher_MatchRule(t *testing.T) {
+	m, err := NewRegexMatcher(`^ad[0-9]+\.`, `(^|\.)doubleclick\.net$`, `^[a-z]{3}\.cn$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"ad12.example.com", `^ad[0-9]+\.`},
+		{"AD1.example.com", `^ad[0-9]+\.`},
+		{"pad12.example.com", ""},
+		{"stats.doubleclick.net", `(^|\.)doubleclick\.net$`},
+		{"doubleclick.net.cn", ""},
+		{"abc.cn", `^[a-z]{3}\.cn$`},
+	}
+	for _, tt := range tests {
+		if got := m.MatchRule(tt.arg); got != tt.want {
+			t.Errorf("RegexMatcher.MatchRule(%s) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+
+	// the first expression declared wins, with or without required literal
+	m, err = NewRegexMatcher(`^ads\.`, `^[a-z]+\.example\.com$`, `.*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.MatchRule("www.example.com"), `^[a-z]+\.example\.com$`; got != want {
+		t.Errorf("RegexMatcher.MatchRule(www.example.com) = %s, want %s", got, want)
+	}
+
+	if _, err := NewRegexMatcher(`(`); err == nil {
+		t.Error("NewRegexMatcher() invalid expression without error")
+	}
+}
+
+func TestRequiredLiteral(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`^ad[0-9]+\.example\.com$`, ".example.com"},
+		{`(^|\.)doubleclick\.net$`, "doubleclick.net"},
+		{`(?i)Tracker`, "tracker"},
+		{`a|b`, ""},
+		{`(google)video`, "google"},
+	}
+	for _, tt := range tests {
+		if got := requiredLiteral(tt.expr); got != tt.want {
+			t.Errorf("requiredLiteral(%s) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func benchDomains() []string {
+	return []string{"www.google.com", "r1---sn-a5mekn7s.googlevideo.com", "ad1234.tracker99.net", "not.matched.example.org"}
+}
+
+func BenchmarkNode_Match(b *testing.B) {
+	rules := make([]string, 5000)
+	for i := range rules {
+		rules[i] = "**.domain" + strconv.Itoa(i) + ".com"
+	}
+	n := NewNodeFromRules(rules...)
+	domains := benchDomains()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n.Match(domains[i%len(domains)])
+	}
+}
+
+func benchRules(count int) []string {
+	rules := make([]string, count)
+	for i := range rules {
+		switch i % 3 {
+		case 0:
+			rules[i] = "**.domain" + strconv.Itoa(i) + ".com"
+		case 1:
+			rules[i] = "*.cdn" + strconv.Itoa(i) + ".net"
+		default:
+			rules[i] = "www.site" + strconv.Itoa(i) + ".org"
+		}
+	}
+	return rules
+}
+
+func BenchmarkNode_Match100k(b *testing.B) {
+	n := NewNodeFromRules(benchRules(100000)...)
+	domains := append(benchDomains(), "a.b.domain99999.com", "x.cdn1.net", "www.site2.org")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n.Match(domains[i%len(domains)])
+	}
+}
+
+func BenchmarkNode_Match100kParallel(b *testing.B) {
+	n := NewNodeFromRules(benchRules(100000)...)
+	domains := append(benchDomains(), "a.b.domain99999.com", "x.cdn1.net", "www.site2.org")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			n.Match(domains[i%len(domains)])
+		}
+	})
+}
+
+func BenchmarkNode_Add100k(b *testing.B) {
+	n := NewNodeFromRules(benchRules(100000)...)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n.Add("new" + strconv.Itoa(i) + ".example.com")
+	}
+}
+
+func BenchmarkKeywordMatcher_Match(b *testing.B) {
+	keywords := make([]string, 5000)
+	for i := range keywords {
+		keywords[i] = "keyword" + strconv.Itoa(i)
+	}
+	m := NewKeywordMatcher(append(keywords, "googlevideo")...)
+	domains := benchDomains()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Match(domains[i%len(domains)])
+	}
+}
+
+func BenchmarkRegexMatcher_Match(b *testing.B) {
+	exprs := make([]string, 2000)
+	for i := range exprs {
+		exprs[i] = `^ad[0-9]+\.tracker` + strconv.Itoa(i) + `\.net$`
+	}
+	m, err := NewRegexMatcher(exprs...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	domains := benchDomains()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Match(domains[i%len(domains)])
+	}
+}
@@ -0,0 +1,100 @@
+package util
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// RegexMatcher match strings by regular expressions.
+// The literal every match must contain is extracted from each expression as a keyword,
+// so only the expressions whose keyword appears are evaluated.
+type RegexMatcher struct {
+	exprs    []*regexp.Regexp
+	keywords *KeywordMatcher
+	ids      []int // keyword id => expression index
+	always   []int // expressions without literal
+}
+
+// NewRegexMatcher compile the expressions in RE2 syntax, matched case insensitive
+func NewRegexMatcher(exprs ...string) (*RegexMatcher, error) {
+	m := &RegexMatcher{}
+	keywords := []string{}
+	for i, expr := range exprs {
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, err
+		}
+		m.exprs = append(m.exprs, re)
+
+		if lit := requiredLiteral(expr); lit != "" {
+			keywords = append(keywords, lit)
+			m.ids = append(m.ids, i)
+		} else {
+			m.always = append(m.always, i)
+		}
+	}
+
+	m.keywords = NewKeywordMatcher(keywords...)
+	return m, nil
+}
+
+// requiredLiteral return the longest literal at the top level of expr, empty if not found
+func requiredLiteral(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	re = re.Simplify()
+
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+
+	lit := ""
+	for _, sub := range subs {
+		if sub.Op == syntax.OpCapture && len(sub.Sub) == 1 {
+			sub = sub.Sub[0]
+		}
+		if sub.Op == syntax.OpLiteral && len(string(sub.Rune)) > len(lit) {
+			lit = string(sub.Rune)
+		}
+	}
+	return strings.ToLower(lit)
+}
+
+// Match check if any expression matches s
+func (m *RegexMatcher) Match(s string) bool {
+	return m.MatchRule(s) != ""
+}
+
+// MatchRule return the expression matches s, empty if none
+func (m *RegexMatcher) MatchRule(s string) string {
+	if m == nil {
+		return ""
+	}
+
+	// the first expression declared wins, as if all of them were tried in order
+	candidates := append([]int{}, m.always...)
+	m.keywords.MatchID(s, func(id int) bool {
+		candidates = append(candidates, m.ids[id])
+		return false
+	})
+	sort.Ints(candidates)
+
+	for j, i := range candidates {
+		if j > 0 && candidates[j-1] == i {
+			continue
+		}
+		if m.exprs[i].MatchString(s) {
+			return m.source(i)
+		}
+	}
+	return ""
+}
+
+func (m *RegexMatcher) source(i int) string {
+	return strings.TrimPrefix(m.exprs[i].String(), "(?i)")
+}
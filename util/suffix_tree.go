@@ -3,29 +3,63 @@ package util
 import (
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
+// Node match domains by rules with wildcards, eg: a.wweir.cc / *.wweir.cc / **.wweir.cc.
+// The compiled tree is immutable once published, writers publish a new copy atomically,
+// so lookups are lock free and never allocate.
 type Node struct {
-	node
-	sep string
-	*sync.RWMutex
+	root atomic.Value // *node
+	mu   sync.Mutex   // serialize writers
 }
 type node struct {
 	node map[string]*node
+	rule string // the rule ends here
 }
 
+// end marks the rule ends at the node
+var end = &node{}
+
 func NewNodeFromRules(rules ...string) *Node {
-	n := &Node{node{node: map[string]*node{}}, ".", &sync.RWMutex{}}
-	for i := range rules {
-		n.Add(rules[i])
-	}
+	n := &Node{}
+	n.root.Store(compile(rules))
 	return n
 }
 
+// compile build a private tree in place, it is never changed after published
+func compile(rules []string) *node {
+	root := &node{node: map[string]*node{}}
+	for _, rule := range rules {
+		root.add(split(rule), rule)
+	}
+	return root
+}
+
+func trim(item string) string {
+	return strings.TrimSuffix(item, ".")
+}
+
+// split the rule into sections, nil if any section is empty
+func split(rule string) []string {
+	secs := strings.Split(trim(rule), ".")
+	for _, sec := range secs {
+		if sec == "" {
+			return nil
+		}
+	}
+	return secs
+}
+
+func (n *Node) load() *node {
+	if root, ok := n.root.Load().(*node); ok {
+		return root
+	}
+	return end
+}
+
 func (n *Node) String() string {
-	n.RLock()
-	defer n.RUnlock()
-	return n.string("", "     ")
+	return n.load().string("", "     ")
 }
 func (n *node) string(prefix, indent string) (out string) {
 	for key, val := range n.node {
@@ -34,22 +68,33 @@ func (n *node) string(prefix, indent string) (out string) {
 	return
 }
 
-func (n *Node) trim(item string) string {
-	return strings.TrimSuffix(item, n.sep)
+// Add insert the rule by copy on write, the lookups in flight keep the old tree
+func (n *Node) Add(item string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.root.Store(n.load().with(split(item), item))
 }
 
-func (n *Node) Add(item string) {
-	n.Lock()
-	defer n.Unlock()
-	n.add(strings.Split(n.trim(item), n.sep))
+// Replace publish a new tree compiled from the rules
+func (n *Node) Replace(rules ...string) {
+	root := compile(rules)
+	n.mu.Lock()
+	n.root.Store(root)
+	n.mu.Unlock()
 }
-func (n *node) add(secs []string) {
+
+// add change the private tree in place
+func (n *node) add(secs []string, rule string) {
 	length := len(secs)
 	switch length {
 	case 0:
 		return
 	case 1:
-		n.node[secs[length-1]] = &node{node: map[string]*node{"": &node{}}}
+		if subNode, ok := n.node[secs[0]]; ok {
+			subNode.node[""], subNode.rule = end, rule // keep the sub rules
+		} else {
+			n.node[secs[0]] = &node{node: map[string]*node{"": end}, rule: rule}
+		}
 	default:
 		sec := secs[length-1]
 		if sec == "**" {
@@ -61,45 +106,152 @@ func (n *node) add(secs []string) {
 			subNode = &node{node: map[string]*node{}}
 			n.node[sec] = subNode
 		}
-		subNode.add(secs[:length-1])
+		subNode.add(secs[:length-1], rule)
+	}
+}
+
+// with return a copy of n with the rule added, the nodes off the path are shared
+func (n *node) with(secs []string, rule string) *node {
+	length := len(secs)
+	if length == 0 {
+		return n
+	}
+
+	c := n.clone()
+	sec := secs[length-1]
+	if length == 1 {
+		subNode, ok := n.node[sec]
+		if ok {
+			subNode = subNode.clone()
+		} else {
+			subNode = &node{node: map[string]*node{}}
+		}
+		subNode.node[""], subNode.rule = end, rule
+		c.node[sec] = subNode
+		return c
 	}
+
+	if sec == "**" {
+		sec = "*"
+	}
+	subNode, ok := n.node[sec]
+	if !ok {
+		subNode = &node{node: map[string]*node{}}
+	}
+	c.node[sec] = subNode.with(secs[:length-1], rule)
+	return c
+}
+
+// Remove delete the rule by copy on write, and prune the empty branches.
+// Return false if the rule is not found, eg: a.*.com for a.**.com.
+func (n *Node) Remove(item string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	root, ok := n.load().without(split(item), trim(item))
+	if ok {
+		n.root.Store(root)
+	}
+	return ok
+}
+
+// without return a copy of n with the rule removed, the nodes off the path are shared.
+// Note that a.*.com and a.**.com share the same path, the rule ends there tells them.
+func (n *node) without(secs []string, rule string) (*node, bool) {
+	length := len(secs)
+	if length == 0 {
+		return n, false
+	}
+
+	sec := secs[length-1]
+	if length > 1 && sec == "**" {
+		sec = "*"
+	}
+	subNode, ok := n.node[sec]
+	if !ok {
+		return n, false
+	}
+
+	if length == 1 {
+		if _, ok := subNode.node[""]; !ok || trim(subNode.rule) != rule {
+			return n, false
+		}
+		subNode = subNode.clone()
+		delete(subNode.node, "")
+		subNode.rule = ""
+	} else if subNode, ok = subNode.without(secs[:length-1], rule); !ok {
+		return n, false
+	}
+
+	c := n.clone()
+	if len(subNode.node) == 0 {
+		delete(c.node, sec)
+	} else {
+		c.node[sec] = subNode
+	}
+	return c, true
+}
+
+func (n *node) clone() *node {
+	c := &node{node: make(map[string]*node, len(n.node)+1), rule: n.rule}
+	for key, val := range n.node {
+		c.node[key] = val
+	}
+	return c
 }
 
 func (n *Node) Match(item string) bool {
 	if n == nil {
 		return false
 	}
-	return n.matchSecs(strings.Split(n.trim(item), n.sep), false)
+	return n.load().match(trim(item), true, false) != nil
 }
 
-func (n *node) matchSecs(secs []string, fuzzNode bool) bool {
-	length := len(secs)
-	if length == 0 {
+// MatchRule return the rule matched the item, eg: **.wweir.cc for a.wweir.cc
+func (n *Node) MatchRule(item string) (string, bool) {
+	if n == nil {
+		return "", false
+	}
+	if leaf := n.load().match(trim(item), true, false); leaf != nil {
+		return leaf.rule, true
+	}
+	return "", false
+}
+
+// match the sections of s from right to left, more is false if no section left.
+// Return the node the matched rule ends.
+func (n *node) match(s string, more, fuzzNode bool) *node {
+	if !more {
 		if _, ok := n.node[""]; ok {
-			return true
+			return n
 		}
-		if _, ok := n.node["**"]; ok {
-			return true
+		if leaf, ok := n.node["**"]; ok {
+			return leaf
 		}
-		if _, ok := n.node["*"]; ok {
-			return !fuzzNode
+		if leaf, ok := n.node["*"]; ok && !fuzzNode {
+			return leaf
 		}
-		return false
+		return nil
 	}
 
-	if n, ok := n.node[secs[length-1]]; ok {
-		if n.matchSecs(secs[:length-1], false) {
-			return true
+	sec, rest := s, ""
+	idx := strings.LastIndexByte(s, '.')
+	if idx >= 0 {
+		sec, rest = s[idx+1:], s[:idx]
+	}
+
+	if n, ok := n.node[sec]; ok {
+		if leaf := n.match(rest, idx >= 0, false); leaf != nil {
+			return leaf
 		}
 	}
 	if n, ok := n.node["*"]; ok {
-		if n.matchSecs(secs[:length-1], true) {
-			return true
+		if leaf := n.match(rest, idx >= 0, true); leaf != nil {
+			return leaf
 		}
 	}
-	if _, ok := n.node["**"]; ok {
-		return true
+	if leaf, ok := n.node["**"]; ok {
+		return leaf
 	}
-
-	return false
+	return nil
 }
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"strconv"
 	"testing"
 )
 
@@ -77,3 +78,132 @@ func TestNode_Match(t *testing.T) {
 		})
 	}
 }
+
+func TestNode_MatchRule(t *testing.T) {
+	node := NewNodeFromRules("a.wweir.cc", "wweir.cc", "*.wweir.cc", "**.cc", "a.**.com", "iamp.*.*")
+	tests := []struct {
+		arg  string
+		want string
+		ok   bool
+	}{
+		{"a.wweir.cc", "a.wweir.cc", true},
+		{"wweir.cc", "wweir.cc", true},
+		{"b.wweir.cc", "*.wweir.cc", true},
+		{"a.b.wweir.cc", "**.cc", true},
+		{"a.fuzz.com", "a.**.com", true},
+		{"iamp.wweir.net", "iamp.*.*", true},
+		{"b.fuzz.com", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := node.MatchRule(tt.arg); got != tt.want || ok != tt.ok {
+			t.Errorf("Node.MatchRule(%s) = %s, %v, want %s, %v", tt.arg, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNode_Concurrent(t *testing.T) {
+	node := NewNodeFromRules("**.wweir.cc")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			node.Add("a" + strconv.Itoa(i) + ".wweir.com")
+		}
+		node.Replace("**.wweir.cc", "**.wweir.com")
+	}()
+
+	for i := 0; i < 1000; i++ {
+		if !node.Match("a.wweir.cc") {
+			t.Fatal("Node.Match(a.wweir.cc) = false while adding rules")
+		}
+		node.MatchRule("a" + strconv.Itoa(i) + ".wweir.com")
+	}
+	<-done
+
+	if rule, _ := node.MatchRule("a1.wweir.com"); rule != "**.wweir.com" {
+		t.Errorf("Node.MatchRule(a1.wweir.com) = %s, want **.wweir.com", rule)
+	}
+}
+
+func TestNode_MatchAllocs(t *testing.T) {
+	node := NewNodeFromRules("**.wweir.cc", "a.*.com", "iamp.*.*")
+	allocs := testing.AllocsPerRun(100, func() {
+		node.Match("a.b.wweir.cc")
+		node.Match("not.matched.example.org.")
+		node.MatchRule("a.fuzz.com")
+	})
+	if allocs != 0 {
+		t.Errorf("Node.Match allocates %v times, want 0", allocs)
+	}
+}
+
+func TestNode_AddKeepOld(t *testing.T) {
+	node := NewNodeFromRules("a.wweir.cc")
+	old := node.load()
+	node.Add("wweir.cc")
+	node.Add("**.wweir.com")
+
+	if old.match("wweir.cc", true, false) != nil {
+		t.Error("the published tree is changed by Add")
+	}
+	for _, domain := range []string{"a.wweir.cc", "wweir.cc", "b.wweir.com"} {
+		if !node.Match(domain) {
+			t.Errorf("Node.Match(%s) = false, want true", domain)
+		}
+	}
+}
+
+func TestNode_Remove(t *testing.T) {
+	node := NewNodeFromRules("a.wweir.cc", "wweir.cc", "*.wweir.cc", "**.wweir.com", "iamp.*.*")
+	old := node.load()
+
+	for _, rule := range []string{"a.wweir.cc", "**.wweir.com", "iamp.*.*"} {
+		if !node.Remove(rule) {
+			t.Errorf("Node.Remove(%s) = false, want true", rule)
+		}
+	}
+	if node.Remove("b.wweir.cc") || node.Remove("**.wweir.com") {
+		t.Error("Node.Remove() of missing rule = true, want false")
+	}
+
+	tests := []struct {
+		arg  string
+		want string
+		ok   bool
+	}{
+		{"a.wweir.cc", "*.wweir.cc", true},
+		{"wweir.cc", "wweir.cc", true},
+		{"a.wweir.com", "", false},
+		{"iamp.wweir.net", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := node.MatchRule(tt.arg); got != tt.want || ok != tt.ok {
+			t.Errorf("Node.MatchRule(%s) = %s, %v, want %s, %v", tt.arg, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// the empty branches are pruned
+	root := node.load()
+	if _, ok := root.node["com"]; ok {
+		t.Error("branch com is not pruned")
+	}
+	if _, ok := root.node["*"]; ok {
+		t.Error("branch * is not pruned")
+	}
+	if old.match("a.wweir.com", true, false) == nil {
+		t.Error("the published tree is changed by Remove")
+	}
+}
+
+func TestNode_RemoveExact(t *testing.T) {
+	node := NewNodeFromRules("a.**.com", "**.b.com")
+	if node.Remove("a.*.com") || node.Remove("*.b.com") {
+		t.Error("Node.Remove() of the other wildcard = true, want false")
+	}
+	if got, ok := node.MatchRule("a.x.com"); got != "a.**.com" || !ok {
+		t.Errorf("Node.MatchRule(a.x.com) = %s, %v, want a.**.com, true", got, ok)
+	}
+	if !node.Remove("a.**.com") || node.Match("a.x.com") {
+		t.Error("Node.Remove(a.**.com) not removed")
+	}
+}